@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"squ1d/ast"
+	"squ1d/compiler"
+	"squ1d/evaluator"
+	"squ1d/lexer"
+	"squ1d/object"
+	"squ1d/optimizer"
+	"squ1d/parser"
+	"squ1d/repl"
+	"squ1d/resolver"
+	"squ1d/vm"
+	"strings"
+)
+
+func main() {
+	engine := flag.String("engine", "eval", "execution engine: eval (tree-walking) or vm (bytecode)")
+	optimize := flag.Bool("optimize", false, "fold constants and prune dead branches before running a file")
+	dumpAST := flag.Bool("dump-ast", false, "print the program's syntax tree, after optimization, instead of running it")
+	flag.Parse()
+
+	if *engine != "eval" && *engine != "vm" {
+		fmt.Printf("Unknown engine %s, expected eval or vm\n", *engine)
+		os.Exit(2)
+	}
+
+	if flag.NArg() > 0 {
+		// File mode
+		filename := flag.Arg(0)
+		runFile(filename, *engine, *optimize, *dumpAST)
+	} else {
+		// REPL mode
+		user, err := user.Current()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Hello %s! This is the SQU1D programming language!\n", user.Username)
+		fmt.Printf("Feel free to type in commands\n")
+		if *engine == "vm" {
+			repl.StartVM(os.Stdin, os.Stdout)
+		} else {
+			repl.Start(os.Stdin, os.Stdout)
+		}
+	}
+}
+
+func runFile(filename string, engine string, optimize bool, dumpAST bool) {
+	// Check file extension
+	expectedFormat := ".sqd"
+	actualFormat := strings.ToLower(filepath.Ext(filename))
+
+	if actualFormat != expectedFormat {
+		fmt.Printf("Incorrect file format: Got %s, expected %s\n", actualFormat, expectedFormat)
+		return
+	}
+
+	// Read file
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Printf("Failed to read file %s: %s\n", filename, err)
+		return
+	}
+
+	code := string(data)
+
+	// Run code through the ususal lexer > parser > evaluator pipeline
+	l := lexer.New(code)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Println("Parser error: ", msg)
+		}
+		return
+	}
+
+	if optimize {
+		program = optimizer.Optimize(program)
+	}
+
+	if dumpAST {
+		for _, statement := range program.Statements {
+			fmt.Println(statement.String())
+		}
+		return
+	}
+
+	r := resolver.New(evaluator.BuiltinNames()...)
+	if errors := r.Resolve(program); len(errors) != 0 {
+		for _, msg := range errors {
+			fmt.Println("Parser error: ", msg)
+		}
+		return
+	}
+
+	var evaluated object.Object
+	if engine == "vm" {
+		evaluated = runVM(program)
+	} else {
+		// The runtime bounds how deeply calls nest, so runaway recursion
+		// ends in an error rather than a crash.
+		env := object.NewEnvironment()
+		env.SetRuntime(&object.Runtime{})
+		evaluated = evaluator.Eval(program, env)
+	}
+
+	if evaluated != nil {
+		fmt.Println(evaluated.Inspect())
+	}
+}
+
+func runVM(program *ast.Program) object.Object {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		fmt.Println("Compiler error: ", err)
+		return nil
+	}
+
+	machine := vm.New(comp.Bytecode())
+	machine.SetRuntime(&object.Runtime{})
+	if err := machine.Run(); err != nil {
+		fmt.Println("VM error: ", err)
+		return nil
+	}
+
+	return machine.Result()
+}
@@ -0,0 +1,308 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpIn
+	OpNotIn
+	OpRange
+	OpRangeInclusive
+
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpJumpNotTruthy
+	OpJump
+
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+	OpCurrentClosure
+
+	OpArray
+	OpHash
+	OpSet
+	OpIndex
+
+	OpClosure
+	OpCall
+	OpReturnValue
+	OpReturn
+
+	OpError
+	OpGetCell
+	OpSetCell
+	OpDeref
+
+	OpSpread
+	OpConcat
+	OpMerge
+	OpCallSpread
+
+	OpGetField
+	OpSetField
+	OpMethodCall
+	OpMethodCallSpread
+	OpClass
+	OpInherit
+
+	OpDestructureArray
+	OpDestructureHash
+	OpMatchArray
+	OpMatchField
+	OpMatchType
+
+	OpClearResult
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd:            {"OpAdd", []int{}},
+	OpSub:            {"OpSub", []int{}},
+	OpMul:            {"OpMul", []int{}},
+	OpDiv:            {"OpDiv", []int{}},
+	OpEqual:          {"OpEqual", []int{}},
+	OpNotEqual:       {"OpNotEqual", []int{}},
+	OpGreaterThan:    {"OpGreaterThan", []int{}},
+	OpLessThan:       {"OpLessThan", []int{}},
+	OpIn:             {"OpIn", []int{}},
+	OpNotIn:          {"OpNotIn", []int{}},
+	OpRange:          {"OpRange", []int{}},
+	OpRangeInclusive: {"OpRangeInclusive", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJump:          {"OpJump", []int{2}},
+
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+	OpSet:   {"OpSet", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+
+	// OpClosure takes the constant index of the compiled function and the
+	// number of free variables sitting on the stack.
+	OpClosure:     {"OpClosure", []int{2, 1}},
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+
+	// OpError stops the program with the message in the given string
+	// constant, for errors the compiler finds but the evaluator would only
+	// report once the code runs.
+	OpError: {"OpError", []int{2}},
+	// OpGetCell and OpSetCell reach a local through the cell it is kept in
+	// once a closure has captured it before it was assigned. OpDeref
+	// replaces a cell, or a global, with its value and jumps to its
+	// operand; if it has not been assigned yet it is dropped, and the
+	// instructions that follow load the name the evaluator would fall
+	// back to.
+	OpGetCell: {"OpGetCell", []int{1}},
+	OpSetCell: {"OpSetCell", []int{1}},
+	OpDeref:   {"OpDeref", []int{2}},
+
+	// OpSpread turns an array, set or range into an array of its
+	// elements, and OpConcat joins that many arrays into one. OpMerge
+	// joins that many hashes. OpCallSpread calls with the arguments in the
+	// array on top of the stack.
+	OpSpread:     {"OpSpread", []int{}},
+	OpConcat:     {"OpConcat", []int{2}},
+	OpMerge:      {"OpMerge", []int{2}},
+	OpCallSpread: {"OpCallSpread", []int{}},
+
+	// The field, method and class opcodes take the constant index of a
+	// name. OpMethodCall also takes the number of arguments, and OpClass
+	// the number of name and method pairs on the stack.
+	OpGetField:         {"OpGetField", []int{2}},
+	OpSetField:         {"OpSetField", []int{2}},
+	OpMethodCall:       {"OpMethodCall", []int{2, 1}},
+	OpMethodCallSpread: {"OpMethodCallSpread", []int{2}},
+	OpClass:            {"OpClass", []int{2, 1}},
+	OpInherit:          {"OpInherit", []int{}},
+
+	// OpDestructureArray takes the number of elements and whether the
+	// rest is collected; OpDestructureHash the constant index of an array
+	// of key names. Both push the parts with the first on top.
+	OpDestructureArray: {"OpDestructureArray", []int{2, 1}},
+	OpDestructureHash:  {"OpDestructureHash", []int{2}},
+	// OpMatchArray pushes whether the value is an array of that many
+	// elements, or at least that many when there is a rest. OpMatchField
+	// pushes the field named by the key on top of the stack, or jumps to
+	// its operand if there is none. OpMatchType pushes whether the value's
+	// type has the name in the given constant.
+	OpMatchArray: {"OpMatchArray", []int{2, 1}},
+	OpMatchField: {"OpMatchField", []int{2}},
+	OpMatchType:  {"OpMatchType", []int{2}},
+
+	// OpClearResult forgets the last value popped, for a program that ends
+	// in a declaration and so, as in the evaluator, has no result.
+	OpClearResult: {"OpClearResult", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Check returns an error if an operand does not fit in the width op gives
+// it, which Make would silently truncate.
+func Check(op Opcode, operands ...int) error {
+	def, ok := definitions[op]
+	if !ok {
+		return fmt.Errorf("opcode %d undefined", op)
+	}
+
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		if o < 0 || o >= 1<<(8*width) {
+			return fmt.Errorf("operand %d of %s does not fit in %d byte(s)", o, def.Name, width)
+		}
+	}
+
+	return nil
+}
+
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
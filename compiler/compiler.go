@@ -0,0 +1,1072 @@
+package compiler
+
+import (
+	"fmt"
+	"squ1d/ast"
+	"squ1d/code"
+	"squ1d/evaluator"
+	"squ1d/object"
+	"squ1d/token"
+)
+
+// Compiler turns a program into bytecode for the vm. Errors the evaluator
+// only reports once the faulty code runs, such as an unknown identifier,
+// are compiled into instructions that raise them, so both engines fail at
+// the same point. Only spreads in hash patterns, which the evaluator gives
+// no meaning, and programs too large for an instruction's operands, such as
+// a function with more than 256 locals, are reported as compile errors.
+type Compiler struct {
+	// BlockScoping gives every if/el body its own scope, as
+	// object.Runtime's BlockScoping does for the evaluator. It is true
+	// by default.
+	BlockScoping bool
+
+	constants []object.Object
+	// names maps each name added by addName to its constant.
+	names map[string]int
+
+	symbolTable *SymbolTable
+
+	// err is the first operand found not to fit its instruction, reported
+	// once the program is compiled.
+	err error
+
+	scopes     []CompilationScope
+	scopeIndex int
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+}
+
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":      code.OpAdd,
+	"-":      code.OpSub,
+	"*":      code.OpMul,
+	"/":      code.OpDiv,
+	"==":     code.OpEqual,
+	"!=":     code.OpNotEqual,
+	">":      code.OpGreaterThan,
+	"<":      code.OpLessThan,
+	"in":     code.OpIn,
+	"not in": code.OpNotIn,
+	"..":     code.OpRange,
+	"..=":    code.OpRangeInclusive,
+}
+
+var prefixOpcodes = map[string]code.Opcode{
+	"-": code.OpMinus,
+	"!": code.OpBang,
+}
+
+func New() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, name := range evaluator.BuiltinNames() {
+		symbolTable.DefineBuiltin(i, name)
+	}
+
+	return &Compiler{
+		BlockScoping: true,
+		constants:    []object.Object{},
+		names:        map[string]int{},
+		symbolTable:  symbolTable,
+		scopes:       []CompilationScope{{instructions: code.Instructions{}}},
+	}
+}
+
+// NewWithState continues compiling against the symbols and constants of an
+// earlier compilation, which lets the REPL keep its globals between lines.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+// SymbolTable returns the global symbol table, for use with NewWithState.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		if err := c.compileStatements(node.Statements); err != nil {
+			return err
+		}
+		if popsValues(node.Statements) && !endsInExpression(node.Statements) {
+			c.emit(code.OpClearResult)
+		}
+		return c.err
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		return c.compileStatements(node.Statements)
+
+	case *ast.LetStatement:
+		constant := node.Token.Type == token.CONST
+		if node.Pattern != nil {
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+			c.destructure(node.Pattern, constant)
+			return nil
+		}
+		// Define after compiling the value so `var x = x` reads the
+		// enclosing x, as the evaluator does.
+		if err := c.compileFunctionValue(node.Value, node.Name.Value); err != nil {
+			return err
+		}
+		c.bind(node.Name.Value, constant)
+
+	case *ast.FunctionStatement:
+		// Compiled up front by hoistFunctions.
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.Identifier:
+		c.compileIdentifier(node.Value)
+
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.PrefixExpression:
+		op, ok := prefixOpcodes[node.Operator]
+		if !ok {
+			return unsupported(node)
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+
+	case *ast.InfixExpression:
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return unsupported(node)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+
+	case *ast.IfExpression:
+		var alternative ast.Node
+		if node.Alternative != nil {
+			alternative = node.Alternative
+		}
+		return c.compileConditional(node.Condition, node.Consequence, alternative, true)
+
+	case *ast.TernaryExpression:
+		return c.compileConditional(node.Condition, node.Consequence, node.Alternative, false)
+
+	case *ast.ArrayLiteral:
+		if hasSpread(node.Elements) {
+			return c.compileSpreadList(node.Elements)
+		}
+		if err := c.compileExpressions(node.Elements); err != nil {
+			return err
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.SetLiteral:
+		if err := c.compileExpressions(node.Elements); err != nil {
+			return err
+		}
+		c.emit(code.OpSet, len(node.Elements))
+
+	case *ast.HashLiteral:
+		if hasSpread(node.Entries) {
+			return c.compileHashSpreads(node)
+		}
+
+		for _, k := range node.Entries {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node, node.Name)
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		if hasSpread(node.Arguments) {
+			if err := c.compileSpreadList(node.Arguments); err != nil {
+				return err
+			}
+			c.emit(code.OpCallSpread)
+			return nil
+		}
+		if err := c.compileExpressions(node.Arguments); err != nil {
+			return err
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+
+	case *ast.StructStatement:
+		fields := make([]string, len(node.Fields))
+		for i, f := range node.Fields {
+			fields[i] = f.Value
+		}
+		structType := &object.StructType{Name: node.Name.Value, Fields: fields}
+		c.emit(code.OpConstant, c.addConstant(structType))
+		c.bind(node.Name.Value, false)
+
+	case *ast.ClassStatement:
+		return c.compileClass(node)
+
+	case *ast.DotExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		c.emit(code.OpGetField, c.addName(node.Field.Value))
+
+	case *ast.AssignExpression:
+		target, ok := node.Target.(*ast.DotExpression)
+		if !ok {
+			c.emitError("Invalid assignment target: %s", node.Target.String())
+			return nil
+		}
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetField, c.addName(target.Field.Value))
+
+	case *ast.MethodCallExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		name := c.addName(node.Method.Value)
+		if hasSpread(node.Arguments) {
+			if err := c.compileSpreadList(node.Arguments); err != nil {
+				return err
+			}
+			c.emit(code.OpMethodCallSpread, name)
+			return nil
+		}
+		if err := c.compileExpressions(node.Arguments); err != nil {
+			return err
+		}
+		c.emit(code.OpMethodCall, name, len(node.Arguments))
+
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+
+	default:
+		return unsupported(node)
+	}
+
+	return nil
+}
+
+func unsupported(node ast.Node) error {
+	return fmt.Errorf("Not supported by the vm: %s", node.String())
+}
+
+// endsInExpression reports whether the last of statements is an expression
+// statement, whose value they yield. Statements ending in a declaration
+// yield nothing, whatever the expressions before it were.
+func endsInExpression(statements []ast.Statement) bool {
+	if len(statements) == 0 {
+		return false
+	}
+	_, ok := statements[len(statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// popsValues reports whether any of statements is an expression statement,
+// which leaves its value to the vm as the last one popped.
+func popsValues(statements []ast.Statement) bool {
+	for _, s := range statements {
+		if _, ok := s.(*ast.ExpressionStatement); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	c.declare(statements)
+
+	if err := c.hoistFunctions(statements); err != nil {
+		return err
+	}
+
+	for _, s := range statements {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// declare records the names statements bind, so nested functions compiled
+// before a binding runs can still refer to it.
+func (c *Compiler) declare(statements []ast.Statement) {
+	for _, s := range statements {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			switch pattern := s.Pattern.(type) {
+			case *ast.ArrayPattern:
+				for _, name := range pattern.Elements {
+					c.symbolTable.Declare(name.Value)
+				}
+				if pattern.Rest != nil {
+					c.symbolTable.Declare(pattern.Rest.Value)
+				}
+			case *ast.HashPattern:
+				for _, name := range pattern.Keys {
+					c.symbolTable.Declare(name.Value)
+				}
+			default:
+				c.symbolTable.Declare(s.Name.Value)
+			}
+		case *ast.StructStatement:
+			c.symbolTable.Declare(s.Name.Value)
+		case *ast.ClassStatement:
+			c.symbolTable.Declare(s.Name.Value)
+		}
+	}
+}
+
+// hoistFunctions defines every named function declared in statements before
+// any of them run, matching the evaluator. Names they use that the
+// enclosing statements bind later resolve to forward symbols.
+func (c *Compiler) hoistFunctions(statements []ast.Statement) error {
+	declarations := []*ast.FunctionStatement{}
+	symbols := []Symbol{}
+
+	for _, s := range statements {
+		if fs, ok := s.(*ast.FunctionStatement); ok {
+			declarations = append(declarations, fs)
+			symbols = append(symbols, c.symbolTable.Define(fs.Name.Value))
+		}
+	}
+
+	for i, fs := range declarations {
+		if err := c.compileFunction(fs.Function, fs.Name.Value); err != nil {
+			return err
+		}
+		c.setSymbol(symbols[i])
+	}
+
+	return nil
+}
+
+func hasSpread(expressions []ast.Expression) bool {
+	for _, e := range expressions {
+		if _, ok := e.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSpreadList leaves an array of the values of expressions on the
+// stack, with spreads expanded in place.
+func (c *Compiler) compileSpreadList(expressions []ast.Expression) error {
+	parts, pending := 0, 0
+	for _, e := range expressions {
+		spread, ok := e.(*ast.SpreadExpression)
+		if !ok {
+			if err := c.Compile(e); err != nil {
+				return err
+			}
+			pending++
+			continue
+		}
+
+		if pending > 0 {
+			c.emit(code.OpArray, pending)
+			parts, pending = parts+1, 0
+		}
+		if err := c.Compile(spread.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSpread)
+		parts++
+	}
+	if pending > 0 {
+		c.emit(code.OpArray, pending)
+		parts++
+	}
+
+	c.emit(code.OpConcat, parts)
+	return nil
+}
+
+// compileHashSpreads builds a hash literal containing spreads by merging
+// its runs of pairs and its spread hashes in order, so later keys win.
+func (c *Compiler) compileHashSpreads(node *ast.HashLiteral) error {
+	parts, pending := 0, 0
+	for _, k := range node.Entries {
+		spread, ok := k.(*ast.SpreadExpression)
+		if !ok {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+			pending++
+			continue
+		}
+
+		if pending > 0 {
+			c.emit(code.OpHash, pending*2)
+			parts, pending = parts+1, 0
+		}
+		if err := c.Compile(spread.Value); err != nil {
+			return err
+		}
+		parts++
+	}
+	if pending > 0 {
+		c.emit(code.OpHash, pending*2)
+		parts++
+	}
+
+	c.emit(code.OpMerge, parts)
+	return nil
+}
+
+func (c *Compiler) compileExpressions(expressions []ast.Expression) error {
+	for _, e := range expressions {
+		if _, ok := e.(*ast.SpreadExpression); ok {
+			return unsupported(e)
+		}
+		if err := c.Compile(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileFunctionValue compiles value, letting it refer to itself by the
+// variable name it is assigned to when it is a function literal.
+func (c *Compiler) compileFunctionValue(value ast.Expression, name string) error {
+	if fl, ok := value.(*ast.FunctionLiteral); ok && fl.Name == "" {
+		return c.compileFunction(fl, name)
+	}
+	return c.Compile(value)
+}
+
+// compileConditional compiles if/el and ternaries. Block bodies leave the
+// value of their last expression statement on the stack, or null.
+func (c *Compiler) compileConditional(condition ast.Expression, consequence, alternative ast.Node, blocks bool) error {
+	if err := c.Compile(condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBranch(consequence, blocks); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBranch(alternative, blocks); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+func (c *Compiler) compileBranch(branch ast.Node, block bool) error {
+	if !block {
+		return c.Compile(branch)
+	}
+
+	if c.BlockScoping {
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+		defer func() { c.symbolTable = c.symbolTable.Outer }()
+	}
+
+	return c.compileBlockValue(branch.(*ast.BlockStatement))
+}
+
+// compileBlockValue compiles block so that it leaves the value of its last
+// statement on the stack if that is an expression statement, or null.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+
+	if endsInExpression(block.Statements) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	return nil
+}
+
+// compileMatch tries the arms in order, each in a scope of its own holding
+// the names its pattern binds. With no matching arm the result is null.
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+	subject := c.symbolTable.DefineTemp()
+	c.setSymbol(subject)
+
+	ends := []int{}
+	for _, arm := range node.Arms {
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+
+		fails, err := c.compilePattern(arm.Pattern, subject)
+		if err == nil && arm.Guard != nil {
+			if err = c.Compile(arm.Guard); err == nil {
+				fails = append(fails, c.emit(code.OpJumpNotTruthy, 9999))
+			}
+		}
+		if err == nil {
+			err = c.compileBlockValue(arm.Body)
+		}
+
+		c.symbolTable = c.symbolTable.Outer
+		if err != nil {
+			return err
+		}
+
+		ends = append(ends, c.emit(code.OpJump, 9999))
+		for _, pos := range fails {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+	}
+
+	c.emit(code.OpNull)
+	for _, pos := range ends {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// compilePattern emits the test of the value in subject against pattern,
+// binding the names it holds, and returns the positions of the jumps taken
+// when it does not match. The patterns are those of evaluator's
+// matchPattern.
+func (c *Compiler) compilePattern(pattern ast.Expression, subject Symbol) ([]int, error) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			c.loadSymbol(subject)
+			c.setSymbol(c.symbolTable.Define(pattern.Value))
+		}
+		return nil, nil
+
+	case *ast.ArrayLiteral:
+		return c.compileArrayPattern(pattern, subject)
+
+	case *ast.HashLiteral:
+		fails := []int{}
+		for _, k := range pattern.Entries {
+			if _, ok := k.(*ast.SpreadExpression); ok {
+				return nil, unsupported(pattern)
+			}
+
+			c.loadSymbol(subject)
+			if ident, ok := k.(*ast.Identifier); ok {
+				c.emit(code.OpConstant, c.addName(ident.Value))
+			} else if err := c.Compile(k); err != nil {
+				return nil, err
+			}
+			fails = append(fails, c.emit(code.OpMatchField, 9999))
+
+			field := c.symbolTable.DefineTemp()
+			c.setSymbol(field)
+			matched, err := c.compilePattern(pattern.Pairs[k], field)
+			if err != nil {
+				return nil, err
+			}
+			fails = append(fails, matched...)
+		}
+		return fails, nil
+
+	case *ast.CallExpression:
+		if name, ok := pattern.Function.(*ast.Identifier); ok {
+			if len(pattern.Arguments) > 1 {
+				c.emitError("Type pattern %s takes at most 1 argument, got %d", name.Value, len(pattern.Arguments))
+				return nil, nil
+			}
+
+			c.loadSymbol(subject)
+			c.emit(code.OpMatchType, c.addName(name.Value))
+			fails := []int{c.emit(code.OpJumpNotTruthy, 9999)}
+			if len(pattern.Arguments) == 0 {
+				return fails, nil
+			}
+
+			matched, err := c.compilePattern(pattern.Arguments[0], subject)
+			return append(fails, matched...), err
+		}
+	}
+
+	if err := c.Compile(pattern); err != nil {
+		return nil, err
+	}
+	c.loadSymbol(subject)
+	c.emit(code.OpEqual)
+	return []int{c.emit(code.OpJumpNotTruthy, 9999)}, nil
+}
+
+func (c *Compiler) compileArrayPattern(pattern *ast.ArrayLiteral, subject Symbol) ([]int, error) {
+	elements := pattern.Elements
+	var rest *ast.Identifier
+	if n := len(elements); n > 0 {
+		if spread, ok := elements[n-1].(*ast.SpreadExpression); ok {
+			rest, ok = spread.Value.(*ast.Identifier)
+			if !ok {
+				// Only arrays get as far as checking the rest.
+				c.loadSymbol(subject)
+				c.emit(code.OpMatchArray, 0, 1)
+				fails := []int{c.emit(code.OpJumpNotTruthy, 9999)}
+				c.emitError("Invalid rest pattern: %s", spread.String())
+				return fails, nil
+			}
+			elements = elements[:n-1]
+		}
+	}
+
+	hasRest := 0
+	if rest != nil {
+		hasRest = 1
+	}
+
+	c.loadSymbol(subject)
+	c.emit(code.OpMatchArray, len(elements), hasRest)
+	fails := []int{c.emit(code.OpJumpNotTruthy, 9999)}
+
+	c.loadSymbol(subject)
+	c.emit(code.OpDestructureArray, len(elements), hasRest)
+	parts := make([]Symbol, len(elements)+hasRest)
+	for i := range parts {
+		parts[i] = c.symbolTable.DefineTemp()
+		c.setSymbol(parts[i])
+	}
+
+	for i, el := range elements {
+		matched, err := c.compilePattern(el, parts[i])
+		if err != nil {
+			return nil, err
+		}
+		fails = append(fails, matched...)
+	}
+	if rest != nil {
+		matched, err := c.compilePattern(rest, parts[len(elements)])
+		if err != nil {
+			return nil, err
+		}
+		fails = append(fails, matched...)
+	}
+
+	return fails, nil
+}
+
+// compileClass emits the class's methods as closures taking self, and super
+// when there is a superclass, after their parameters.
+func (c *Compiler) compileClass(node *ast.ClassStatement) error {
+	receivers := []string{"self"}
+	if node.Superclass != nil {
+		receivers = append(receivers, "super")
+	}
+
+	for _, method := range node.Methods {
+		c.emit(code.OpConstant, c.addName(method.Name))
+		if err := c.compileClosure(method, node.Name.Value+"."+method.Name, "", receivers...); err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpClass, c.addName(node.Name.Value), len(node.Methods))
+
+	if node.Superclass != nil {
+		c.compileIdentifier(node.Superclass.Value)
+		c.emit(code.OpInherit)
+	}
+
+	c.bind(node.Name.Value, false)
+	return nil
+}
+
+// compileFunction emits a closure for fl. Inside the body, selfName refers to
+// the closure itself.
+func (c *Compiler) compileFunction(fl *ast.FunctionLiteral, selfName string) error {
+	return c.compileClosure(fl, fl.Name, selfName)
+}
+
+// compileClosure emits a closure for fl called name. The receivers are
+// extra parameters the vm passes after the arguments, such as a method's
+// self.
+func (c *Compiler) compileClosure(fl *ast.FunctionLiteral, name, selfName string, receivers ...string) error {
+	c.enterScope()
+
+	if selfName != "" {
+		c.symbolTable.DefineFunctionName(selfName)
+	}
+
+	params := make([]Symbol, len(fl.Parameters))
+	for i, p := range fl.Parameters {
+		params[i] = c.symbolTable.DefineParameter(p.Value)
+	}
+	for _, r := range receivers {
+		c.symbolTable.DefineParameter(r)
+	}
+
+	for i, pattern := range fl.Patterns {
+		if pattern != nil {
+			c.loadSymbol(params[i])
+			c.destructure(pattern, false)
+		}
+	}
+	prologue := len(c.currentInstructions())
+
+	if err := c.Compile(fl.Body); err != nil {
+		c.leaveScope()
+		return err
+	}
+
+	if endsInExpression(fl.Body.Statements) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.NumDefinitions()
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Name:          name,
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(fl.Parameters),
+		Prologue:      prologue,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	return nil
+}
+
+// destructure binds the parts of the value on top of the stack to the
+// names in an array or hash pattern.
+func (c *Compiler) destructure(pattern ast.Expression, constant bool) {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		hasRest := 0
+		if pattern.Rest != nil {
+			hasRest = 1
+		}
+		c.emit(code.OpDestructureArray, len(pattern.Elements), hasRest)
+		for _, name := range pattern.Elements {
+			c.bind(name.Value, constant)
+		}
+		if pattern.Rest != nil {
+			c.bind(pattern.Rest.Value, constant)
+		}
+
+	case *ast.HashPattern:
+		names := make([]object.Object, len(pattern.Keys))
+		for i, key := range pattern.Keys {
+			names[i] = &object.String{Value: key.Value}
+		}
+		c.emit(code.OpDestructureHash, c.addConstant(&object.Array{Elements: names}))
+		for _, key := range pattern.Keys {
+			c.bind(key.Value, constant)
+		}
+	}
+}
+
+// bind stores the value on top of the stack in name, refusing to rebind a
+// constant of the same scope.
+func (c *Compiler) bind(name string, constant bool) {
+	if c.symbolTable.IsConstant(name) {
+		c.emitError("Cannot reassign constant: %s", name)
+		return
+	}
+	if constant {
+		c.setSymbol(c.symbolTable.DefineConstant(name))
+	} else {
+		c.setSymbol(c.symbolTable.Define(name))
+	}
+}
+
+func (c *Compiler) compileIdentifier(name string) {
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok {
+		c.emitError("Identifier not found: %s", name)
+		return
+	}
+	c.loadSymbol(symbol)
+}
+
+// emitError emits an instruction that stops the program with the error
+// the evaluator would raise on reaching the same code.
+func (c *Compiler) emitError(format string, a ...interface{}) {
+	message := &object.String{Value: fmt.Sprintf(format, a...)}
+	c.emit(code.OpError, c.addConstant(message))
+}
+
+// addName adds a name, such as a field or method name, as a string
+// constant, reusing the constant of a name already added.
+func (c *Compiler) addName(name string) int {
+	if index, ok := c.names[name]; ok {
+		return index
+	}
+	index := c.addConstant(&object.String{Value: name})
+	c.names[name] = index
+	return index
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	if s.Forward {
+		c.loadForward(s)
+		return
+	}
+
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// loadForward loads a name that may be read before it is assigned. Until
+// then the evaluator looks the name up in the globals, and so does the
+// code emitted here.
+func (c *Compiler) loadForward(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+	deref := c.emit(code.OpDeref, 9999)
+
+	if s.Scope == GlobalScope {
+		c.loadBuiltin(s.Name)
+	} else if global, ok := c.symbolTable.Global().resolve(s.Name, true); ok {
+		c.loadSymbol(global)
+	} else {
+		c.emitError("Identifier not found: %s", s.Name)
+	}
+
+	c.changeOperand(deref, len(c.currentInstructions()))
+}
+
+// loadBuiltin loads the builtin called name, even where a global hides it.
+func (c *Compiler) loadBuiltin(name string) {
+	for i, builtin := range evaluator.BuiltinNames() {
+		if builtin == name {
+			c.emit(code.OpGetBuiltin, i)
+			return
+		}
+	}
+	c.emitError("Identifier not found: %s", name)
+}
+
+// captureSymbol loads s to be captured by a closure. Forward locals are
+// captured as their cell, so the closure sees them once they are assigned.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch {
+	case s.Forward && s.Scope == LocalScope:
+		c.emit(code.OpGetCell, s.Index)
+	case s.Forward && s.Scope == FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+func (c *Compiler) setSymbol(s Symbol) {
+	switch {
+	case s.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case s.Forward:
+		c.emit(code.OpSetCell, s.Index)
+	default:
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.check(op, operands...)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+// check records an error if an operand does not fit in op's instruction.
+func (c *Compiler) check(op code.Opcode, operands ...int) {
+	if err := code.Check(op, operands...); err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.check(op, operand)
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}})
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
@@ -0,0 +1,200 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+
+	// Forward marks a name a nested function uses before the scope that
+	// declares it has assigned it. A forward local is kept in a cell the
+	// function can capture before it holds a value.
+	Forward bool
+}
+
+// SymbolTable maps names to storage slots. Function bodies get a new table
+// with their own slots; if/el bodies get a block table that hides its names
+// from the enclosing code but allocates slots from the enclosing function.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+	block          bool
+
+	// declared holds the names the statements of this scope bind, and
+	// consts those of them bound with const.
+	declared map[string]bool
+	consts   map[string]bool
+
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		store:    make(map[string]Symbol),
+		declared: make(map[string]bool),
+		consts:   make(map[string]bool),
+	}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewEnclosedSymbolTable(outer)
+	s.block = true
+	return s
+}
+
+// Global returns the outermost table, which holds the globals and builtins.
+func (s *SymbolTable) Global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
+
+// owner is the table whose slots this table allocates from.
+func (s *SymbolTable) owner() *SymbolTable {
+	for s.block {
+		s = s.Outer
+	}
+	return s
+}
+
+// NumDefinitions is the number of slots allocated in this function scope,
+// including those used by nested blocks.
+func (s *SymbolTable) NumDefinitions() int {
+	return s.owner().numDefinitions
+}
+
+// Define binds name in this scope. Redeclaring a name reuses its slot, as
+// the evaluator rebinds it in the same environment.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+
+	symbol := s.allocate(name)
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineConstant binds name like Define and refuses later bindings of it
+// in this scope.
+func (s *SymbolTable) DefineConstant(name string) Symbol {
+	symbol := s.Define(name)
+	s.consts[name] = true
+	return symbol
+}
+
+// IsConstant reports whether name was bound with const in this scope.
+func (s *SymbolTable) IsConstant(name string) bool {
+	return s.consts[name]
+}
+
+// DefineParameter binds a function parameter. Every parameter gets its own
+// slot, in order, even if a name is repeated.
+func (s *SymbolTable) DefineParameter(name string) Symbol {
+	symbol := s.allocate(name)
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineTemp allocates a slot no name refers to, for values the compiled
+// code keeps aside, such as the subject of a match.
+func (s *SymbolTable) DefineTemp() Symbol {
+	return s.allocate("")
+}
+
+// Declare records that the statements of this scope bind name, so a
+// nested function using it before then still finds it here.
+func (s *SymbolTable) Declare(name string) {
+	s.declared[name] = true
+}
+
+func (s *SymbolTable) allocate(name string) Symbol {
+	owner := s.owner()
+
+	symbol := Symbol{Name: name, Index: owner.numDefinitions}
+	if owner.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	owner.numDefinitions++
+	return symbol
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName lets a function refer to itself by name without
+// capturing a free variable that would not be set yet.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Forward: original.Forward}
+	symbol.Scope = FreeScope
+
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Resolve finds the symbol name refers to. A name a nested function uses
+// before the enclosing scope declaring it binds it resolves to a forward
+// symbol there, as the evaluator resolves function bodies only once their
+// enclosing scope is complete.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+func (s *SymbolTable) resolve(name string, nested bool) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok {
+		return symbol, ok
+	}
+	if nested && s.declared[name] {
+		symbol = s.allocate(name)
+		symbol.Forward = true
+		s.store[name] = symbol
+		return symbol, true
+	}
+	if s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.resolve(name, nested || !s.block)
+	if !ok || s.block {
+		return symbol, ok
+	}
+
+	if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol, ok
+	}
+
+	return s.defineFree(symbol), true
+}
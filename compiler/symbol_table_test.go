@@ -0,0 +1,79 @@
+package compiler
+
+import "testing"
+
+func TestDefineAndResolve(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+	b := local.Define("b")
+
+	block := NewBlockSymbolTable(local)
+	c := block.Define("c")
+	shadow := block.Define("b")
+
+	nested := NewEnclosedSymbolTable(block)
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{global, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{local, "a", a},
+		{local, "b", b},
+		{block, "b", shadow},
+		{block, "c", c},
+		{nested, "a", a},
+		{nested, "c", Symbol{Name: "c", Scope: FreeScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		result, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got %+v", tt.name, tt.expected, result)
+		}
+	}
+
+	if c != (Symbol{Name: "c", Scope: LocalScope, Index: 1}) || shadow.Index != 2 {
+		t.Errorf("block symbols should take slots from the function: %+v %+v", c, shadow)
+	}
+	if local.NumDefinitions() != 3 {
+		t.Errorf("expected 3 local slots, got %d", local.NumDefinitions())
+	}
+	if _, ok := local.Resolve("c"); ok {
+		t.Errorf("block symbol c leaked into the enclosing scope")
+	}
+	if len(block.FreeSymbols) != 0 {
+		t.Errorf("block table should not capture free symbols, got %+v", block.FreeSymbols)
+	}
+}
+
+func TestResolveBuiltinsAndFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(3, "cat")
+
+	local := NewEnclosedSymbolTable(global)
+	local.DefineFunctionName("f")
+
+	expected := map[string]Symbol{
+		"cat": {Name: "cat", Scope: BuiltinScope, Index: 3},
+		"f":   {Name: "f", Scope: FunctionScope, Index: 0},
+	}
+
+	for name, want := range expected {
+		result, ok := local.Resolve(name)
+		if !ok || result != want {
+			t.Errorf("expected %s to resolve to %+v, got %+v", name, want, result)
+		}
+	}
+
+	if _, ok := local.Resolve("missing"); ok {
+		t.Errorf("unknown name resolved")
+	}
+}
@@ -0,0 +1,568 @@
+package squ1d
+
+import (
+	"fmt"
+	"reflect"
+	"squ1d/evaluator"
+	"squ1d/object"
+)
+
+var (
+	valueType = reflect.TypeOf(Value{})
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// ValueOf converts a Go value to a SQU1D one. Integers, strings and
+// booleans map to their SQU1D counterparts, slices and arrays to arrays,
+// and maps and structs to hashes. Struct fields are keyed by name, or by
+// their `squ1d` tag; a tag of "-" leaves the field out. Pointers and
+// interfaces convert to what they point at, nil to null, and functions are
+// wrapped as by Func.
+func ValueOf(x interface{}) (Value, error) {
+	if v, ok := x.(Value); ok {
+		return v, nil
+	}
+	obj, err := toObject(reflect.ValueOf(x), nil)
+	if err != nil {
+		return Null, err
+	}
+	return valueOf(obj), nil
+}
+
+// visit identifies a pointer, map or slice being converted, so toObject can
+// tell a value that contains itself.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// toObject converts rv. seen holds the values rv is nested in, and may be
+// nil at the top.
+func toObject(rv reflect.Value, seen map[visit]bool) (object.Object, error) {
+	if !rv.IsValid() {
+		return evaluator.NULL, nil
+	}
+	if rv.Type() == valueType {
+		return rv.Interface().(Value).Object(), nil
+	}
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.CanInterface() {
+		if obj, ok := rv.Interface().(object.Object); ok {
+			return obj, nil
+		}
+	}
+
+	if key, ok := visitOf(rv); ok {
+		if seen[key] {
+			return nil, fmt.Errorf("cannot convert %s: it contains itself", rv.Type())
+		}
+		if seen == nil {
+			seen = make(map[visit]bool)
+		}
+		seen[key] = true
+		defer delete(seen, key)
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return Bool(rv.Bool()).Object(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return object.NewInteger(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := rv.Uint()
+		if n > 1<<63-1 {
+			return nil, fmt.Errorf("cannot convert %d to an integer: out of range", n)
+		}
+		return object.NewInteger(int64(n)), nil
+	case reflect.String:
+		return &object.String{Value: rv.String()}, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return toObject(rv.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return evaluator.NULL, nil
+		}
+		elements := make([]object.Object, rv.Len())
+		for i := range elements {
+			el, err := toObject(rv.Index(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return evaluator.NULL, nil
+		}
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair, rv.Len())}
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := toObject(iter.Key(), seen)
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("cannot use %s as a hash key", key.Type())
+			}
+			value, err := toObject(iter.Value(), seen)
+			if err != nil {
+				return nil, err
+			}
+			hash.Pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+		}
+		return hash, nil
+	case reflect.Struct:
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		for i := 0; i < rv.NumField(); i++ {
+			name, ok := fieldName(rv.Type().Field(i))
+			if !ok {
+				continue
+			}
+			value, err := toObject(rv.Field(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			key := &object.String{Value: name}
+			hash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: value}
+		}
+		return hash, nil
+	case reflect.Func:
+		if rv.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return wrapFunc("", rv)
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a SQU1D value", rv.Type())
+	}
+}
+
+// visitOf returns the key toObject tracks rv under, if rv can lead back to
+// itself.
+func visitOf(rv reflect.Value) (visit, bool) {
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map:
+		if !rv.IsNil() {
+			return visit{rv.Pointer(), rv.Type(), 0}, true
+		}
+	case reflect.Slice:
+		if rv.Len() > 0 {
+			return visit{rv.Pointer(), rv.Type(), rv.Len()}, true
+		}
+	}
+	return visit{}, false
+}
+
+// fieldName returns the hash key of an exported struct field.
+func fieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("squ1d")
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return field.Name, true
+}
+
+// Interface returns v as a plain Go value: int64, string, bool, nil,
+// []interface{} for arrays, sets and ranges, and map[string]interface{} for
+// hashes with string keys, structs and instances. Other hashes become
+// map[interface{}]interface{}, and values with no Go counterpart, such as
+// functions, are returned as a Value. A value that contains itself, which
+// field assignment can make, cannot be converted.
+func (v Value) Interface() (interface{}, error) {
+	return toInterface(v.Object(), nil)
+}
+
+// toInterface converts obj. seen holds the objects obj is nested in, and
+// may be nil at the top.
+func toInterface(obj object.Object, seen map[object.Object]bool) (interface{}, error) {
+	if isContainer(obj) {
+		if seen[obj] {
+			return nil, fmt.Errorf("cannot convert %s: it contains itself", obj.Type())
+		}
+		if seen == nil {
+			seen = make(map[object.Object]bool)
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+	}
+
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Null:
+		return nil, nil
+	case *object.Array:
+		return interfaces(obj.Elements, seen)
+	case *object.Set:
+		return interfaces(obj.Values(), seen)
+	case *object.Range:
+		if obj.Len() > object.MaxRangeValues {
+			return nil, fmt.Errorf("cannot convert %s: too long", obj.Inspect())
+		}
+		return interfaces(obj.Values(), seen)
+	case *object.Hash:
+		stringKeys := make(map[string]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return anyKeyInterfaces(obj, seen)
+			}
+			value, err := toInterface(pair.Value, seen)
+			if err != nil {
+				return nil, err
+			}
+			stringKeys[key.Value] = value
+		}
+		return stringKeys, nil
+	case *object.Struct:
+		return fieldInterfaces(obj.Fields, seen)
+	case *object.Instance:
+		return fieldInterfaces(obj.Fields, seen)
+	default:
+		return valueOf(obj), nil
+	}
+}
+
+// isContainer reports whether obj holds other objects, and so may hold
+// itself.
+func isContainer(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Array, *object.Hash, *object.Set, *object.Struct, *object.Instance:
+		return true
+	}
+	return false
+}
+
+func interfaces(objects []object.Object, seen map[object.Object]bool) ([]interface{}, error) {
+	values := make([]interface{}, len(objects))
+	for i, obj := range objects {
+		value, err := toInterface(obj, seen)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func anyKeyInterfaces(hash *object.Hash, seen map[object.Object]bool) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{}, len(hash.Pairs))
+	for _, pair := range hash.Pairs {
+		key, err := toInterface(pair.Key, seen)
+		if err != nil {
+			return nil, err
+		}
+		value, err := toInterface(pair.Value, seen)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func fieldInterfaces(fields map[string]object.Object, seen map[object.Object]bool) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(fields))
+	for name, field := range fields {
+		value, err := toInterface(field, seen)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// Decode stores v in the Go value target points to, converting it the way
+// ValueOf converts the other way. Hashes, structs and instances decode into
+// Go structs by field name or `squ1d` tag.
+func (v Value) Decode(target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("cannot decode into %T: not a non-nil pointer", target)
+	}
+	return fromObject(v.Object(), rv.Elem(), nil)
+}
+
+// fromObject stores obj in target. seen holds the objects obj is nested
+// in, and may be nil at the top.
+func fromObject(obj object.Object, target reflect.Value, seen map[object.Object]bool) error {
+	if target.Type() == valueType {
+		target.Set(reflect.ValueOf(valueOf(obj)))
+		return nil
+	}
+
+	// A pointer or interface target passes obj on whole, so obj is marked
+	// only where it is taken apart.
+	if isContainer(obj) && target.Kind() != reflect.Ptr && target.Kind() != reflect.Interface {
+		if seen[obj] {
+			return fmt.Errorf("cannot convert %s: it contains itself", obj.Type())
+		}
+		if seen == nil {
+			seen = make(map[object.Object]bool)
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+	}
+
+	switch target.Kind() {
+	case reflect.Interface:
+		if target.NumMethod() != 0 {
+			break
+		}
+		value, err := toInterface(obj, nil)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			target.Set(reflect.ValueOf(value))
+		} else {
+			target.Set(reflect.Zero(target.Type()))
+		}
+		return nil
+	case reflect.Ptr:
+		if obj == evaluator.NULL {
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+		elem := reflect.New(target.Type().Elem())
+		if err := fromObject(obj, elem.Elem(), seen); err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if integer, ok := obj.(*object.Integer); ok {
+			if target.OverflowInt(integer.Value) {
+				return fmt.Errorf("cannot convert %d to %s: out of range", integer.Value, target.Type())
+			}
+			target.SetInt(integer.Value)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if integer, ok := obj.(*object.Integer); ok {
+			if integer.Value < 0 || target.OverflowUint(uint64(integer.Value)) {
+				return fmt.Errorf("cannot convert %d to %s: out of range", integer.Value, target.Type())
+			}
+			target.SetUint(uint64(integer.Value))
+			return nil
+		}
+	case reflect.String:
+		if str, ok := obj.(*object.String); ok {
+			target.SetString(str.Value)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			target.SetBool(b.Value)
+			return nil
+		}
+	case reflect.Slice:
+		if elements, ok := listElements(obj); ok {
+			slice := reflect.MakeSlice(target.Type(), len(elements), len(elements))
+			for i, el := range elements {
+				if err := fromObject(el, slice.Index(i), seen); err != nil {
+					return err
+				}
+			}
+			target.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if elements, ok := listElements(obj); ok {
+			if len(elements) != target.Len() {
+				return fmt.Errorf("cannot convert ARRAY of length %d to %s", len(elements), target.Type())
+			}
+			for i, el := range elements {
+				if err := fromObject(el, target.Index(i), seen); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if hash, ok := obj.(*object.Hash); ok {
+			m := reflect.MakeMapWithSize(target.Type(), len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				key := reflect.New(target.Type().Key()).Elem()
+				if err := fromObject(pair.Key, key, seen); err != nil {
+					return err
+				}
+				value := reflect.New(target.Type().Elem()).Elem()
+				if err := fromObject(pair.Value, value, seen); err != nil {
+					return err
+				}
+				m.SetMapIndex(key, value)
+			}
+			target.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if fields, ok := objectFields(obj); ok {
+			for i := 0; i < target.NumField(); i++ {
+				name, ok := fieldName(target.Type().Field(i))
+				if !ok {
+					continue
+				}
+				field, ok := fields[name]
+				if !ok {
+					continue
+				}
+				if err := fromObject(field, target.Field(i), seen); err != nil {
+					return fmt.Errorf("field %s: %s", name, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), target.Type())
+}
+
+func listElements(obj object.Object) ([]object.Object, bool) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		return obj.Elements, true
+	case *object.Set:
+		return obj.Values(), true
+	case *object.Range:
+		if obj.Len() > object.MaxRangeValues {
+			return nil, false
+		}
+		return obj.Values(), true
+	default:
+		return nil, false
+	}
+}
+
+func objectFields(obj object.Object) (map[string]object.Object, bool) {
+	switch obj := obj.(type) {
+	case *object.Hash:
+		fields := make(map[string]object.Object, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			if key, ok := pair.Key.(*object.String); ok {
+				fields[key.Value] = pair.Value
+			}
+		}
+		return fields, true
+	case *object.Struct:
+		return obj.Fields, true
+	case *object.Instance:
+		return obj.Fields, true
+	default:
+		return nil, false
+	}
+}
+
+// Func wraps the Go function fn as a builtin. Arguments are checked and
+// converted with Decode, and results with ValueOf. fn may return nothing,
+// a value, an error, or a value and an error; a non-nil error is raised in
+// the script as a runtime error.
+func Func(fn interface{}) (*object.Builtin, error) {
+	return wrapFunc("", reflect.ValueOf(fn))
+}
+
+// RegisterFunc makes the Go function fn callable from scripts as name, as
+// wrapped by Func.
+func (i *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	builtin, err := wrapFunc(name, reflect.ValueOf(fn))
+	if err != nil {
+		return err
+	}
+	i.builtins[name] = builtin
+	i.resolver.Declare(name)
+	return nil
+}
+
+func wrapFunc(name string, fn reflect.Value) (*object.Builtin, error) {
+	if !fn.IsValid() || fn.Kind() != reflect.Func || fn.IsNil() {
+		return nil, fmt.Errorf("cannot wrap %v: not a function", fn)
+	}
+
+	fnType := fn.Type()
+	numOut := fnType.NumOut()
+	returnsError := numOut > 0 && fnType.Out(numOut-1) == errorType
+	if numOut > 2 || (numOut == 2 && !returnsError) {
+		return nil, fmt.Errorf("cannot wrap %s: it must return at most a value and an error", fnType)
+	}
+
+	// to and where name the function in error messages, when it has a
+	// name.
+	to, where := "", ""
+	if name != "" {
+		to, where = " to "+name, " in "+name
+	}
+
+	return &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			numIn := fnType.NumIn()
+			if fnType.IsVariadic() && len(args) < numIn-1 {
+				return &object.Error{Message: fmt.Sprintf("Wrong number of arguments%s. Got %d, expected at least %d",
+					to, len(args), numIn-1)}
+			}
+			if !fnType.IsVariadic() && len(args) != numIn {
+				return &object.Error{Message: fmt.Sprintf("Wrong number of arguments%s. Got %d, expected %d",
+					to, len(args), numIn)}
+			}
+
+			in := make([]reflect.Value, len(args))
+			for idx, arg := range args {
+				var paramType reflect.Type
+				if fnType.IsVariadic() && idx >= numIn-1 {
+					paramType = fnType.In(numIn - 1).Elem()
+				} else {
+					paramType = fnType.In(idx)
+				}
+
+				in[idx] = reflect.New(paramType).Elem()
+				if err := fromObject(arg, in[idx], nil); err != nil {
+					return &object.Error{Message: fmt.Sprintf("Wrong argument %d%s: %s", idx+1, to, err)}
+				}
+			}
+
+			out, recovered := call(fn, in)
+			if recovered != nil {
+				return &object.Error{Message: fmt.Sprintf("Panic%s: %v", where, recovered)}
+			}
+			if returnsError && !out[numOut-1].IsNil() {
+				message := out[numOut-1].Interface().(error).Error()
+				if name != "" {
+					message = name + ": " + message
+				}
+				return &object.Error{Message: message}
+			}
+			if numOut == 0 || (numOut == 1 && returnsError) {
+				return evaluator.NULL
+			}
+
+			result, err := toObject(out[0], nil)
+			if err != nil {
+				return &object.Error{Message: fmt.Sprintf("Cannot convert result%s: %s", to, err)}
+			}
+			return result
+		},
+	}, nil
+}
+
+// call calls fn, returning what it panicked with, if it did, instead of
+// letting the panic unwind through the interpreter.
+func call(fn reflect.Value, in []reflect.Value) (out []reflect.Value, recovered interface{}) {
+	defer func() {
+		recovered = recover()
+	}()
+	return fn.Call(in), nil
+}
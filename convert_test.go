@@ -0,0 +1,236 @@
+package squ1d
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type node struct {
+	Next *node
+}
+
+type point struct {
+	X      int
+	Y      int    `squ1d:"y"`
+	Label  string `squ1d:"-"`
+	hidden int
+}
+
+func TestValueOf(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected interface{}
+	}{
+		{42, int64(42)},
+		{uint8(7), int64(7)},
+		{"hi", "hi"},
+		{true, true},
+		{nil, nil},
+		{[]int{1, 2}, []interface{}{int64(1), int64(2)}},
+		{[2]string{"a", "b"}, []interface{}{"a", "b"}},
+		{[][]bool{{true}, {}}, []interface{}{[]interface{}{true}, []interface{}{}}},
+		{map[string]int{"a": 1}, map[string]interface{}{"a": int64(1)}},
+		{map[int]string{1: "a"}, map[interface{}]interface{}{int64(1): "a"}},
+		{point{X: 1, Y: 2, Label: "p", hidden: 3}, map[string]interface{}{"X": int64(1), "y": int64(2)}},
+		{&point{X: 5}, map[string]interface{}{"X": int64(5), "y": int64(0)}},
+		{(*point)(nil), nil},
+		{Int(3), int64(3)},
+	}
+
+	for _, tt := range tests {
+		value, err := ValueOf(tt.input)
+		if err != nil {
+			t.Errorf("ValueOf(%#v) failed: %s", tt.input, err)
+			continue
+		}
+		if got, err := value.Interface(); err != nil || !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("ValueOf(%#v) wrong. expected=%#v, got=%#v", tt.input, tt.expected, got)
+		}
+	}
+
+	for _, input := range []interface{}{1.5, make(chan int), uint64(1 << 63), []float64{1}} {
+		if _, err := ValueOf(input); err == nil {
+			t.Errorf("ValueOf(%#v) should fail", input)
+		}
+	}
+
+	shared := []int{1}
+	if _, err := ValueOf([][]int{shared, shared}); err != nil {
+		t.Errorf("shared values are not cycles. got=%s", err)
+	}
+
+	loop := &node{}
+	loop.Next = loop
+	list := []interface{}{nil}
+	list[0] = list
+	hash := map[string]interface{}{}
+	hash["self"] = []interface{}{hash}
+	for _, input := range []interface{}{loop, list, hash} {
+		if _, err := ValueOf(input); err == nil || !strings.HasSuffix(err.Error(), "it contains itself") {
+			t.Errorf("ValueOf(%T) should report a cycle. got=%v", input, err)
+		}
+	}
+
+	fn, err := ValueOf(func(n int) int { return n * 2 })
+	if err != nil || fn.Object().Type() != "BUILTIN" {
+		t.Errorf("functions should convert to builtins. got=%s, %v", fn.Object().Type(), err)
+	}
+}
+
+func TestConvertCycles(t *testing.T) {
+	interp := New()
+	result, err := interp.Eval("struct N { Next }; var n = N(1); n.Next = [n]; n")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := result.Interface(); err == nil || err.Error() != "cannot convert STRUCT: it contains itself" {
+		t.Errorf("Interface should report a cycle. got=%v", err)
+	}
+	var any interface{}
+	if err := result.Decode(&any); err == nil || err.Error() != "cannot convert STRUCT: it contains itself" {
+		t.Errorf("Decode into interface{} should report a cycle. got=%v", err)
+	}
+	loop, _ := interp.Eval("var m = N(1); m.Next = m; m")
+	var n node
+	if err := loop.Decode(&n); err == nil || !strings.HasSuffix(err.Error(), "it contains itself") {
+		t.Errorf("Decode into a recursive type should report a cycle. got=%v", err)
+	}
+
+	shared, _ := interp.Eval("var s = [1]; [s, s]")
+	if got, err := shared.Interface(); err != nil || len(got.([]interface{})) != 2 {
+		t.Errorf("shared values are not cycles. got=%v, %v", got, err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	interp := New()
+	result, err := interp.Eval(`{"X": 1, "y": 2, "Label": "ignored", "extra": [1, 2]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var p point
+	if err := result.Decode(&p); err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if p != (point{X: 1, Y: 2}) {
+		t.Errorf("wrong point. got=%+v", p)
+	}
+
+	var m map[string]interface{}
+	if err := result.Decode(&m); err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	expected := map[string]interface{}{
+		"X": int64(1), "y": int64(2), "Label": "ignored", "extra": []interface{}{int64(1), int64(2)},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("wrong map. got=%#v", m)
+	}
+
+	result, _ = interp.Eval("[...1..=3]")
+	var small []int8
+	if err := result.Decode(&small); err != nil || !reflect.DeepEqual(small, []int8{1, 2, 3}) {
+		t.Errorf("wrong slice. got=%v, %v", small, err)
+	}
+	var pair [2]int
+	if err := result.Decode(&pair); err == nil || err.Error() != "cannot convert ARRAY of length 3 to [2]int" {
+		t.Errorf("expected a length error. got=%v", err)
+	}
+
+	result, _ = interp.Eval("0..100000000000")
+	if _, err := result.Interface(); err == nil || err.Error() != "cannot convert 0..100000000000: too long" {
+		t.Errorf("expected a length error from Interface. got=%v", err)
+	}
+	if err := result.Decode(new([]int)); err == nil || err.Error() != "cannot convert RANGE to []int" {
+		t.Errorf("expected a length error for a long range. got=%v", err)
+	}
+
+	result, _ = interp.Eval("300")
+	if err := result.Decode(new(int8)); err == nil || err.Error() != "cannot convert 300 to int8: out of range" {
+		t.Errorf("expected a range error. got=%v", err)
+	}
+	var ptr *int
+	if err := result.Decode(&ptr); err != nil || *ptr != 300 {
+		t.Errorf("wrong pointer. got=%v, %v", ptr, err)
+	}
+
+	if err := result.Decode(new(string)); err == nil || err.Error() != "cannot convert INTEGER to string" {
+		t.Errorf("expected a type error. got=%v", err)
+	}
+	if err := result.Decode(p); err == nil {
+		t.Errorf("Decode should require a pointer")
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	interp := New()
+
+	tests := []struct {
+		name string
+		fn   interface{}
+	}{
+		{"add", func(a, b int) int { return a + b }},
+		{"join", func(sep string, parts ...string) string { return strings.Join(parts, sep) }},
+		{"area", func(p point) int { return p.X * p.Y }},
+		{"origin", func() *point { return &point{} }},
+		{"check", func(n int) (bool, error) {
+			if n < 0 {
+				return false, errors.New("negative")
+			}
+			return n%2 == 0, nil
+		}},
+		{"noop", func() {}},
+		{"crash", func(xs []int) int { return xs[5] }},
+	}
+	for _, tt := range tests {
+		if err := interp.RegisterFunc(tt.name, tt.fn); err != nil {
+			t.Fatalf("RegisterFunc(%s) failed: %s", tt.name, err)
+		}
+	}
+
+	evalTests := []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{"add(1, 2)", "3", ""},
+		{`join("-", "a", "b", "c")`, "a-b-c", ""},
+		{`cat(join("-"))`, "0", ""},
+		{`area({"X": 3, "y": 4})`, "12", ""},
+		{`origin()["y"]`, "0", ""},
+		{"check(4)", "true", ""},
+		{"noop()", "null", ""},
+		{"add(1)", "", "Wrong number of arguments to add. Got 1, expected 2"},
+		{"join()", "", "Wrong number of arguments to join. Got 0, expected at least 1"},
+		{`add(1, "2")`, "", "Wrong argument 2 to add: cannot convert STRING to int"},
+		{"check(-1)", "", "check: negative"},
+		{"crash([1])", "", "Panic in crash: runtime error: index out of range [5] with length 1"},
+	}
+
+	for _, tt := range evalTests {
+		result, err := interp.Eval(tt.input)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q. got=%v", tt.input, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.String() != tt.expected {
+			t.Errorf("%s: wrong result. expected=%q, got=%q", tt.input, tt.expected, result.String())
+		}
+	}
+
+	for _, fn := range []interface{}{nil, 42, func() (int, int) { return 0, 0 }} {
+		if _, err := Func(fn); err == nil {
+			t.Errorf("Func(%T) should fail", fn)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package evaluator
+
+import (
+	"squ1d/ast"
+	"squ1d/lexer"
+	"squ1d/object"
+	"squ1d/parser"
+	"squ1d/resolver"
+	"testing"
+)
+
+// Run with `go test -bench . -benchmem ./evaluator` to track time and
+// allocations per evaluation.
+
+const fibProgram = `
+def fib(n) { n < 2 ? n : fib(n - 1) + fib(n - 2) }
+fib(20)`
+
+// There are no loop statements, so loops are written as tail recursion.
+const loopProgram = `
+def loop(i, acc) { i == 0 ? acc : loop(i - 1, acc + i / 10) }
+def run(n) { n == 0 ? 0 : loop(100, 0) + run(n - 1) }
+run(50)`
+
+const rangeProgram = `
+def sum(xs) { match (xs) { [] => 0, [x, ...rest] => x + sum(rest) } }
+sum([...0..200])`
+
+const stringProgram = `
+def build(n, s) { n == 0 ? s : build(n - 1, s + "ab") }
+cat(build(500, ""))`
+
+func BenchmarkFib(b *testing.B) {
+	benchmarkProgram(b, fibProgram)
+}
+
+func BenchmarkLoop(b *testing.B) {
+	benchmarkProgram(b, loopProgram)
+}
+
+func BenchmarkRangeSum(b *testing.B) {
+	benchmarkProgram(b, rangeProgram)
+}
+
+func BenchmarkStringBuilding(b *testing.B) {
+	benchmarkProgram(b, stringProgram)
+}
+
+func benchmarkProgram(b *testing.B, input string) {
+	program := parseBenchmarkProgram(b, input)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		result := Eval(program, object.NewEnvironment())
+		if err, ok := result.(*object.Error); ok {
+			b.Fatalf("evaluation failed: %s", err.Inspect())
+		}
+	}
+}
+
+func parseBenchmarkProgram(b *testing.B, input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		b.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	r := resolver.New(BuiltinNames()...)
+	if errors := r.Resolve(program); len(errors) != 0 {
+		b.Fatalf("resolver errors: %v", errors)
+	}
+
+	return program
+}
+
+func TestSmallIntegerArithmeticDoesNotAllocate(t *testing.T) {
+	left, right := object.NewInteger(40), object.NewInteger(2)
+
+	for _, operator := range []string{"+", "-", "*", "/", "<", "=="} {
+		allocs := testing.AllocsPerRun(100, func() {
+			evalInfixExpression(operator, left, right)
+		})
+		if allocs != 0 {
+			t.Errorf("%s allocated %.0f times per run", operator, allocs)
+		}
+	}
+}
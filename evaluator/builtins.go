@@ -3,8 +3,10 @@ package evaluator
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"sort"
 	"squ1d/object"
 	"strconv"
 	"strings"
@@ -25,11 +27,10 @@ var builtins = map[string]*object.Builtin{
 				return newError("Arguments must be strings. Got %s and %s", args[0].Type(), args[1].Type())
 			}
 
-			fmt.Print(prompt.Value)
+			fmt.Fprint(stdout(env), prompt.Value)
 
-			reader := bufio.NewReader(os.Stdin)
-			input, err := reader.ReadString('\n')
-			if err != nil {
+			input, err := stdin(env).ReadString('\n')
+			if err != nil && (err != io.EOF || input == "") {
 				return newError("Failed to read input: %s", err.Error())
 			}
 
@@ -38,7 +39,7 @@ var builtins = map[string]*object.Builtin{
 			// Try to parse as integer
 			var value object.Object
 			if intVal, err := strconv.ParseInt(input, 10, 64); err == nil {
-				value = &object.Integer{Value: intVal}
+				value = object.NewInteger(intVal)
 			} else {
 				value = &object.String{Value: input}
 			}
@@ -62,7 +63,7 @@ var builtins = map[string]*object.Builtin{
 				return newError("Failed to convert to integer: %s", err.Error())
 			}
 
-			return &object.Integer{Value: intVal}
+			return object.NewInteger(intVal)
 		},
 	},
 	"rand": &object.Builtin{
@@ -86,7 +87,7 @@ var builtins = map[string]*object.Builtin{
 
 			randNum := rand.Intn(rangeInt) + int(min.Value)
 
-			return &object.Integer{Value: int64(randNum)}
+			return object.NewInteger(int64(randNum))
 		},
 	},
 	"sepr": &object.Builtin{
@@ -118,22 +119,7 @@ var builtins = map[string]*object.Builtin{
 				return newError("Wrong number of arguments. Got %d, expected 1", len(args))
 			}
 
-			switch args[0].(type) {
-			case *object.Array:
-				return &object.String{Value: "array"}
-			case *object.String:
-				return &object.String{Value: "string"}
-			case *object.Hash:
-				return &object.String{Value: "hash"}
-			case *object.Integer:
-				return &object.String{Value: "integer"}
-			case *object.Boolean:
-				return &object.String{Value: "boolean"}
-			case *object.Function:
-				return &object.String{Value: "function"}
-			default:
-				return &object.String{Value: "null"}
-			}
+			return &object.String{Value: typeName(args[0])}
 		},
 	},
 	"cat": &object.Builtin{
@@ -143,9 +129,15 @@ var builtins = map[string]*object.Builtin{
 			}
 			switch arg := args[0].(type) {
 			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
+				return object.NewInteger(int64(len(arg.Elements)))
 			case *object.String:
-				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+				return object.NewInteger(int64(utf8.RuneCountInString(arg.Value)))
+			case *object.Set:
+				return object.NewInteger(int64(len(arg.Elements)))
+			case *object.Hash:
+				return object.NewInteger(int64(len(arg.Pairs)))
+			case *object.Range:
+				return object.NewInteger(arg.Len())
 			default:
 				return newError("Argument to `cat` not supported, got %s",
 					args[0].Type())
@@ -158,6 +150,9 @@ var builtins = map[string]*object.Builtin{
 				return newError("Wrong number of arguments. Got %d, expected 1",
 					len(args))
 			}
+			if rng, ok := args[0].(*object.Range); ok {
+				return evalRangeIndexExpression(rng, object.NewInteger(0))
+			}
 			if args[0].Type() != object.ARRAY_OBJ {
 				return newError("Argument to `first` must be ARRAY, got %s",
 					args[0].Type())
@@ -175,6 +170,9 @@ var builtins = map[string]*object.Builtin{
 				return newError("Wrong number of arguments. Got %d, expected 1",
 					len(args))
 			}
+			if rng, ok := args[0].(*object.Range); ok {
+				return evalRangeIndexExpression(rng, object.NewInteger(rng.Len()-1))
+			}
 			if args[0].Type() != object.ARRAY_OBJ {
 				return newError("Argument to `last` must be ARRAY, got %s",
 					args[0].Type())
@@ -205,14 +203,320 @@ var builtins = map[string]*object.Builtin{
 			return &object.Array{Elements: newElements}
 		},
 	},
+	"set": &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			set := object.NewSet()
+			if len(args) == 0 {
+				return set
+			}
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Got %d, expected 0 or 1",
+					len(args))
+			}
+			if args[0].Type() != object.ARRAY_OBJ {
+				return newError("Argument to `set` must be ARRAY, got %s",
+					args[0].Type())
+			}
+			for _, el := range args[0].(*object.Array).Elements {
+				key, ok := el.(object.Hashable)
+				if !ok {
+					return newError("Unusable as set element: %s", el.Type())
+				}
+				set.Add(key.HashKey(), el)
+			}
+			return set
+		},
+	},
+	"union": &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			a, b, err := setArguments("union", args)
+			if err != nil {
+				return err
+			}
+			result := object.NewSet()
+			for _, key := range a.Order {
+				result.Add(key, a.Elements[key])
+			}
+			for _, key := range b.Order {
+				result.Add(key, b.Elements[key])
+			}
+			return result
+		},
+	},
+	"inter": &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			a, b, err := setArguments("inter", args)
+			if err != nil {
+				return err
+			}
+			result := object.NewSet()
+			for _, key := range a.Order {
+				if b.Contains(key) {
+					result.Add(key, a.Elements[key])
+				}
+			}
+			return result
+		},
+	},
+	"diff": &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			a, b, err := setArguments("diff", args)
+			if err != nil {
+				return err
+			}
+			result := object.NewSet()
+			for _, key := range a.Order {
+				if !b.Contains(key) {
+					result.Add(key, a.Elements[key])
+				}
+			}
+			return result
+		},
+	},
+	"elems": &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Got %d, expected 1",
+					len(args))
+			}
+			switch arg := args[0].(type) {
+			case *object.Set:
+				return &object.Array{Elements: arg.Values()}
+			case *object.Range:
+				values, err := rangeValues(env, arg)
+				if err != nil {
+					return err
+				}
+				return &object.Array{Elements: values}
+			default:
+				return newError("Argument to `elems` not supported, got %s",
+					args[0].Type())
+			}
+		},
+	},
+	"keys": &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Got %d, expected 1",
+					len(args))
+			}
+			if args[0].Type() != object.HASH_OBJ {
+				return newError("Argument to `keys` must be HASH, got %s",
+					args[0].Type())
+			}
+			hash := args[0].(*object.Hash)
+			keys := make([]object.Object, 0, len(hash.Pairs))
+			for _, pair := range sortedPairs(hash) {
+				keys = append(keys, pair.Key)
+			}
+			return &object.Array{Elements: keys}
+		},
+	},
+	"values": &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("Wrong number of arguments. Got %d, expected 1",
+					len(args))
+			}
+			if args[0].Type() != object.HASH_OBJ {
+				return newError("Argument to `values` must be HASH, got %s",
+					args[0].Type())
+			}
+			hash := args[0].(*object.Hash)
+			values := make([]object.Object, 0, len(hash.Pairs))
+			for _, pair := range sortedPairs(hash) {
+				values = append(values, pair.Value)
+			}
+			return &object.Array{Elements: values}
+		},
+	},
 	"write": &object.Builtin{
 		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			out := stdout(env)
 			for _, arg := range args {
-				fmt.Print(arg.Inspect())
+				fmt.Fprint(out, arg.Inspect())
 			}
 
-			fmt.Println()
+			fmt.Fprintln(out)
 			return nil
 		},
 	},
 }
+
+// typeName is the name `tp` reports for obj, which is also the name match
+// type patterns such as integer(n) test against.
+func typeName(obj object.Object) string {
+	switch obj := obj.(type) {
+	case *object.Array:
+		return "array"
+	case *object.String:
+		return "string"
+	case *object.Hash:
+		return "hash"
+	case *object.Set:
+		return "set"
+	case *object.Range:
+		return "range"
+	case *object.Struct:
+		return obj.Definition.Name
+	case *object.StructType:
+		return "struct"
+	case *object.Instance:
+		return obj.Class.Name
+	case *object.Class:
+		return "class"
+	case *object.Integer:
+		return "integer"
+	case *object.Boolean:
+		return "boolean"
+	case *object.Function, *object.Closure:
+		return "function"
+	default:
+		return "null"
+	}
+}
+
+func setArguments(name string, args []object.Object) (*object.Set, *object.Set, *object.Error) {
+	if len(args) != 2 {
+		return nil, nil, newError("Wrong number of arguments. Got %d, expected 2", len(args))
+	}
+	a, ok1 := args[0].(*object.Set)
+	b, ok2 := args[1].(*object.Set)
+	if !ok1 || !ok2 {
+		return nil, nil, newError("Arguments to `%s` must be SET, got %s and %s",
+			name, args[0].Type(), args[1].Type())
+	}
+	return a, b, nil
+}
+
+// sortedPairs lists the pairs of hash ordered by key, integers and strings
+// by value and booleans false first, so keys and values agree from run to
+// run.
+func sortedPairs(hash *object.Hash) []object.HashPair {
+	hashed := make([]object.HashKey, 0, len(hash.Pairs))
+	for key := range hash.Pairs {
+		hashed = append(hashed, key)
+	}
+	sort.Slice(hashed, func(i, j int) bool {
+		a, b := hashed[i], hashed[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		switch a.Type {
+		case object.INTEGER_OBJ:
+			return int64(a.Value) < int64(b.Value)
+		case object.STRING_OBJ:
+			return hash.Pairs[a].Key.(*object.String).Value < hash.Pairs[b].Key.(*object.String).Value
+		}
+		return a.Value < b.Value
+	})
+
+	pairs := make([]object.HashPair, len(hashed))
+	for i, key := range hashed {
+		pairs[i] = hash.Pairs[key]
+	}
+	return pairs
+}
+
+// BuiltinNames returns the names of all builtins in a fixed order, so the
+// compiler and vm can refer to them by index.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// processStdin is shared by every read of the process's standard input, so
+// input one read buffers is there for the next.
+var processStdin = bufio.NewReader(os.Stdin)
+
+// stdin returns the reader the builtins of env's runtime read from.
+func stdin(env *object.Environment) *bufio.Reader {
+	if runtime := env.Runtime(); runtime != nil && runtime.Stdin() != nil {
+		return runtime.Stdin()
+	}
+	return processStdin
+}
+
+// stdout returns the writer the builtins of env's runtime write to.
+func stdout(env *object.Environment) io.Writer {
+	if runtime := env.Runtime(); runtime != nil && runtime.Stdout() != nil {
+		return runtime.Stdout()
+	}
+	return os.Stdout
+}
+
+// lookupBuiltin finds name among the builtins of env's runtime, or among
+// the default builtins if it has not been given any.
+func lookupBuiltin(env *object.Environment, name string) (*object.Builtin, bool) {
+	if runtime := env.Runtime(); runtime != nil && runtime.Builtins() != nil {
+		builtin, ok := runtime.Builtins()[name]
+		return builtin, ok
+	}
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// Capability names a group of builtins that reach outside the interpreter,
+// so an embedder can leave out the ones untrusted code should not have.
+type Capability string
+
+const (
+	CapabilityIO      Capability = "io"
+	CapabilityFS      Capability = "fs"
+	CapabilityNet     Capability = "net"
+	CapabilityTime    Capability = "time"
+	CapabilityRandom  Capability = "random"
+	CapabilityProcess Capability = "process"
+)
+
+// Capabilities lists every capability group.
+var Capabilities = []Capability{
+	CapabilityIO,
+	CapabilityFS,
+	CapabilityNet,
+	CapabilityTime,
+	CapabilityRandom,
+	CapabilityProcess,
+}
+
+// capabilities assigns builtins to the group they need. Builtins left out
+// only compute on their arguments and are always available.
+var capabilities = map[string]Capability{
+	"read":  CapabilityIO,
+	"write": CapabilityIO,
+	"rand":  CapabilityRandom,
+}
+
+// BuiltinCapability returns the group name needs, or false if it needs
+// none.
+func BuiltinCapability(name string) (Capability, bool) {
+	capability, ok := capabilities[name]
+	return capability, ok
+}
+
+// Builtins returns a new set of the builtins that need no capability and
+// those in the granted groups, for object.Runtime.SetBuiltins.
+func Builtins(granted ...Capability) map[string]*object.Builtin {
+	allowed := make(map[Capability]bool, len(granted))
+	for _, capability := range granted {
+		allowed[capability] = true
+	}
+
+	set := make(map[string]*object.Builtin)
+	for name, builtin := range builtins {
+		if capability, ok := capabilities[name]; ok && !allowed[capability] {
+			continue
+		}
+		set[name] = builtin
+	}
+	return set
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"squ1d/ast"
 	"squ1d/object"
+	"squ1d/token"
+	"strings"
 )
 
 var (
@@ -12,6 +14,94 @@ var (
 	FALSE = &object.Boolean{Value: false}
 )
 
+// ApplyPrefix, ApplyInfix, ApplyIndex and IsTruthy expose the evaluator's
+// operator semantics to other engines, such as the vm, so both produce the
+// same values and error messages.
+
+func ApplyPrefix(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func ApplyInfix(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+func ApplyIndex(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// TypeName returns the name the tp builtin gives obj's type.
+func TypeName(obj object.Object) string {
+	return typeName(obj)
+}
+
+// ApplyFunction calls a builtin or struct type with args, charging the call
+// and what it allocates to env's runtime.
+func ApplyFunction(env *object.Environment, fn object.Object, args []object.Object) object.Object {
+	return applyFunction(env, fn, args)
+}
+
+// ApplyBuiltinMethod calls the method name of a built-in type, such as
+// "abc".len().
+func ApplyBuiltinMethod(env *object.Environment, receiver object.Object, name string, args []object.Object) object.Object {
+	return applyBuiltinMethod(env, receiver, name, args)
+}
+
+// GetField and SetField read and assign a field of a struct or instance.
+
+func GetField(obj object.Object, name string) object.Object {
+	return evalDotExpression(obj, name)
+}
+
+func SetField(obj object.Object, name string, value object.Object) object.Object {
+	return setField(obj, name, value)
+}
+
+// Spread lists the elements `...value` stands for, charging a range's to
+// env's runtime.
+func Spread(env *object.Environment, value object.Object) ([]object.Object, object.Object) {
+	return spreadElements(env, value)
+}
+
+// MergeHash copies the pairs of value, which `...value` spreads into a hash
+// literal, into pairs.
+func MergeHash(pairs map[object.HashKey]object.HashPair, value object.Object) object.Object {
+	return mergeHash(pairs, value)
+}
+
+// DestructureArray and DestructureField take value apart for an array or
+// hash pattern. DestructureArray returns the first n elements, followed by
+// an array of the remaining ones if rest is set.
+
+func DestructureArray(value object.Object, n int, rest bool) ([]object.Object, object.Object) {
+	return destructureArray(value, n, rest)
+}
+
+func DestructureField(value object.Object, name string) (object.Object, object.Object) {
+	return destructureField(value, name)
+}
+
+// PatternField and HasTypeName are what hash and type patterns of a match
+// test a value with.
+
+func PatternField(value, key object.Object) (object.Object, bool) {
+	return patternField(value, key)
+}
+
+func HasTypeName(value object.Object, name string) bool {
+	return hasTypeName(value, name)
+}
+
+// Track charges the memory of obj, which has just been created, to env's
+// runtime, and returns it or the error the program must stop with.
+func Track(env *object.Environment, obj object.Object) object.Object {
+	return track(env, obj)
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
@@ -21,7 +111,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return object.NewInteger(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
@@ -37,11 +127,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return track(env, evalInfixExpression(node.Operator, left, right))
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+	case *ast.TernaryExpression:
+		return evalTernaryExpression(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
@@ -53,13 +147,19 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		constant := node.Token.Type == token.CONST
+		if node.Pattern != nil {
+			return destructure(env, node.Pattern, val, constant)
+		}
+		return asError(bind(env, node.Name, val, constant))
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, Patterns: node.Patterns, Env: env, Body: body}
+	case *ast.FunctionStatement:
+		// Bound ahead of time by hoistFunctions.
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
@@ -72,13 +172,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 
 		return applyFunction(env, function, args)
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return track(env, &object.String{Value: node.Value})
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return track(env, &object.Array{Elements: elements})
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -90,13 +190,49 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return track(env, evalHashLiteral(node, env))
+	case *ast.SetLiteral:
+		return track(env, evalSetLiteral(node, env))
+	case *ast.StructStatement:
+		fields := make([]string, len(node.Fields))
+		for i, f := range node.Fields {
+			fields[i] = f.Value
+		}
+		return asError(env.Set(node.Name.Value, &object.StructType{Name: node.Name.Value, Fields: fields}))
+	case *ast.DotExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		return evalDotExpression(left, node.Field.Value)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+	case *ast.ClassStatement:
+		class := evalClassStatement(node, env)
+		if isError(class) {
+			return class
+		}
+		return asError(env.Set(node.Name.Value, class))
+	case *ast.MethodCallExpression:
+		receiver := Eval(node.Object, env)
+		if isError(receiver) {
+			return receiver
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		return evalMethodCall(env, receiver, node.Method.Value, args)
 	}
 
 	return nil
 }
 
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	if err := hoistFunctions(program.Statements, env); err != nil {
+		return err
+	}
+
 	var result object.Object
 	for _, statement := range program.Statements {
 		result = Eval(statement, env)
@@ -111,6 +247,10 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 }
 
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	if err := hoistFunctions(block.Statements, env); err != nil {
+		return err
+	}
+
 	var result object.Object
 	for _, statement := range block.Statements {
 		result = Eval(statement, env)
@@ -124,11 +264,31 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
+// hoistFunctions binds every `def name() {}` declaration in statements
+// before any of them run, so a function may be called above its definition.
+func hoistFunctions(statements []ast.Statement, env *object.Environment) object.Object {
+	for _, statement := range statements {
+		if fs, ok := statement.(*ast.FunctionStatement); ok {
+			if err := asError(bind(env, fs.Name, Eval(fs.Function, env), false)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	// A resolved local that has not been assigned yet falls back to lookup
+	// by name, which finds the global it would otherwise shadow.
+	if node.Resolved {
+		if val, ok := env.GetAt(node.Depth, node.Slot); ok {
+			return val
+		}
+	}
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := lookupBuiltin(env, node.Value); ok {
 		return builtin
 	}
 	return newError("Identifier not found: " + node.Value)
@@ -138,6 +298,15 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	var result []object.Object
 
 	for _, e := range exps {
+		if spread, ok := e.(*ast.SpreadExpression); ok {
+			elements := evalSpreadExpression(spread, env)
+			if len(elements) == 1 && isError(elements[0]) {
+				return elements
+			}
+			result = append(result, elements...)
+			continue
+		}
+
 		evaluated := Eval(e, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
@@ -148,27 +317,165 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
+func evalSpreadExpression(spread *ast.SpreadExpression, env *object.Environment) []object.Object {
+	value := Eval(spread.Value, env)
+	if isError(value) {
+		return []object.Object{value}
+	}
+
+	elements, err := spreadElements(env, value)
+	if err != nil {
+		return []object.Object{err}
+	}
+	return elements
+}
+
+func spreadElements(env *object.Environment, value object.Object) ([]object.Object, object.Object) {
+	switch value := value.(type) {
+	case *object.Array:
+		return value.Elements, nil
+	case *object.Set:
+		return value.Values(), nil
+	case *object.Range:
+		return rangeValues(env, value)
+	default:
+		return nil, newError("Cannot spread %s", value.Type())
+	}
+}
+
 func applyFunction(env *object.Environment, fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
+		if err := step(fn.Env); err != nil {
+			return err
+		}
+		leave, err := enter(fn.Env)
+		if err != nil {
+			return err
+		}
+		defer leave()
+		extendedEnv, errObj := extendFunctionEnv(fn, args)
+		if errObj != nil {
+			return errObj
+		}
 		evaluated := Eval(fn.Body, extendedEnv)
+		if fn.Name != "" {
+			addTraceFrame(evaluated, fn.Name)
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(env, args...)
+		if err := step(env); err != nil {
+			return err
+		}
+		return trackResult(env, fn.Fn(env, args...), args)
+	case *object.StructType:
+		return newStruct(fn, args)
+	case *object.Class:
+		return newInstance(fn, args)
 	default:
 		return newError("Not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+func extendFunctionEnv(fn *object.Function, args []object.Object) (*object.Environment, object.Object) {
+	if len(args) != len(fn.Parameters) {
+		if fn.Name == "" {
+			return nil, newError("Wrong number of arguments. Got %d, expected %d", len(args), len(fn.Parameters))
+		}
+		return nil, newError("Wrong number of arguments to %s. Got %d, expected %d",
+			fn.Name, len(args), len(fn.Parameters))
+	}
+
 	env := object.NewEnclosedEnvironment(fn.Env)
 
+	if err := bindParameters(env, fn, args); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+func bindParameters(env *object.Environment, fn *object.Function, args []object.Object) object.Object {
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if fn.Patterns != nil && fn.Patterns[paramIdx] != nil {
+			if err := destructure(env, fn.Patterns[paramIdx], args[paramIdx], false); err != nil {
+				return err
+			}
+			continue
+		}
+		bind(env, param, args[paramIdx], false)
+	}
+	return nil
+}
+
+// destructure binds the names in an array or hash pattern to the matching
+// parts of value, failing if value does not have the pattern's shape.
+func destructure(env *object.Environment, pattern ast.Expression, value object.Object, constant bool) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		values, err := destructureArray(value, len(pattern.Elements), pattern.Rest != nil)
+		if err != nil {
+			return err
+		}
+		for i, name := range pattern.Elements {
+			if err := asError(bind(env, name, values[i], constant)); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			return asError(bind(env, pattern.Rest, values[len(pattern.Elements)], constant))
+		}
+	case *ast.HashPattern:
+		for _, key := range pattern.Keys {
+			field, err := destructureField(value, key.Value)
+			if err != nil {
+				return err
+			}
+			if err := asError(bind(env, key, field, constant)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func destructureArray(value object.Object, n int, rest bool) ([]object.Object, object.Object) {
+	arr, ok := value.(*object.Array)
+	if !ok {
+		return nil, newError("Cannot destructure %s as ARRAY", value.Type())
+	}
+	if len(arr.Elements) < n || (!rest && len(arr.Elements) > n) {
+		return nil, newError("Cannot destructure ARRAY of length %d into %d elements", len(arr.Elements), n)
 	}
 
-	return env
+	values := make([]object.Object, n, n+1)
+	copy(values, arr.Elements)
+	if rest {
+		remaining := make([]object.Object, len(arr.Elements)-n)
+		copy(remaining, arr.Elements[n:])
+		values = append(values, &object.Array{Elements: remaining})
+	}
+	return values, nil
+}
+
+func destructureField(value object.Object, name string) (object.Object, object.Object) {
+	switch value := value.(type) {
+	case *object.Hash:
+		pair, ok := value.Pairs[(&object.String{Value: name}).HashKey()]
+		if !ok {
+			return nil, newError("Cannot destructure HASH: missing key %q", name)
+		}
+		return pair.Value, nil
+	case *object.Struct, *object.Instance:
+		field := evalDotExpression(value, name)
+		if isError(field) {
+			return nil, field
+		}
+		return field, nil
+	default:
+		return nil, newError("Cannot destructure %s as HASH", value.Type())
+	}
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -216,11 +523,19 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	}
 
 	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return object.NewInteger(-value)
 }
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
+	case operator == "in":
+		return evalInExpression(operator, left, right)
+	case operator == "not in":
+		result := evalInExpression(operator, left, right)
+		if isError(result) {
+			return result
+		}
+		return evalBangOperatorExpression(result)
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
 	case operator == "==":
@@ -242,13 +557,16 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return object.NewInteger(leftVal + rightVal)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return object.NewInteger(leftVal - rightVal)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return object.NewInteger(leftVal * rightVal)
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		if rightVal == 0 {
+			return newError("Division by zero")
+		}
+		return object.NewInteger(leftVal / rightVal)
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -257,15 +575,43 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 		return nativeBoolToBooleanObject(isEqual(left, right))
 	case "!=":
 		return nativeBoolToBooleanObject(!isEqual(left, right))
+	case "..", "..=":
+		rng := &object.Range{Start: leftVal, End: rightVal, Inclusive: operator == "..="}
+		if rng.TooLong() {
+			return newError("Range too long: %s", rng.Inspect())
+		}
+		return rng
 	default:
 		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
 func isEqual(left, right object.Object) bool {
+	return equal(left, right, false, map[[2]object.Object]bool{})
+}
+
+// isSameValue is isEqual, except that arrays and hashes are compared by
+// their contents, as `in` looks for a member by value.
+func isSameValue(left, right object.Object) bool {
+	return equal(left, right, true, map[[2]object.Object]bool{})
+}
+
+// equal compares left and right, recording in seen the pairs of structs,
+// arrays and hashes it has compared. A value can contain itself through
+// field assignment, so a pair met again is taken as equal: any difference
+// is found along the other paths.
+func equal(left, right object.Object, byValue bool, seen map[[2]object.Object]bool) bool {
 	if left.Type() != right.Type() {
 		return false
 	}
+	switch left.(type) {
+	case *object.Struct, *object.Array, *object.Hash:
+		pair := [2]object.Object{left, right}
+		if seen[pair] {
+			return true
+		}
+		seen[pair] = true
+	}
 
 	switch l := left.(type) {
 	case *object.Integer:
@@ -274,11 +620,104 @@ func isEqual(left, right object.Object) bool {
 		return l.Value == right.(*object.Boolean).Value
 	case *object.String:
 		return l.Value == right.(*object.String).Value
+	case *object.Struct:
+		r := right.(*object.Struct)
+		if l.Definition != r.Definition {
+			return false
+		}
+		for name, value := range l.Fields {
+			if !equal(value, r.Fields[name], byValue, seen) {
+				return false
+			}
+		}
+		return true
+	case *object.Array:
+		r := right.(*object.Array)
+		if !byValue || l == r {
+			return l == r
+		}
+		if len(l.Elements) != len(r.Elements) {
+			return false
+		}
+		for i, element := range l.Elements {
+			if !equal(element, r.Elements[i], byValue, seen) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		r := right.(*object.Hash)
+		if !byValue || l == r {
+			return l == r
+		}
+		if len(l.Pairs) != len(r.Pairs) {
+			return false
+		}
+		for key, pair := range l.Pairs {
+			other, ok := r.Pairs[key]
+			if !ok || !equal(pair.Value, other.Value, byValue, seen) {
+				return false
+			}
+		}
+		return true
+	case *object.Set:
+		r := right.(*object.Set)
+		if len(l.Elements) != len(r.Elements) {
+			return false
+		}
+		for key := range l.Elements {
+			if !r.Contains(key) {
+				return false
+			}
+		}
+		return true
+	case *object.Range:
+		r := right.(*object.Range)
+		if l.Len() == 0 || r.Len() == 0 {
+			return l.Len() == r.Len()
+		}
+		return l.Start == r.Start && l.Len() == r.Len()
 	default:
 		return left == right
 	}
 }
 
+func evalInExpression(operator string, left, right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Array:
+		for _, el := range right.Elements {
+			if isSameValue(left, el) {
+				return TRUE
+			}
+		}
+		return FALSE
+	case *object.Hash:
+		key, ok := left.(object.Hashable)
+		if !ok {
+			return newError("Unusable as hash key: %s", left.Type())
+		}
+		_, ok = right.Pairs[key.HashKey()]
+		return nativeBoolToBooleanObject(ok)
+	case *object.String:
+		substr, ok := left.(*object.String)
+		if !ok {
+			return newError("Type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		}
+		return nativeBoolToBooleanObject(strings.Contains(right.Value, substr.Value))
+	case *object.Set:
+		key, ok := left.(object.Hashable)
+		if !ok {
+			return newError("Unusable as set element: %s", left.Type())
+		}
+		return nativeBoolToBooleanObject(right.Contains(key.HashKey()))
+	case *object.Range:
+		n, ok := left.(*object.Integer)
+		return nativeBoolToBooleanObject(ok && right.Contains(n.Value))
+	default:
+		return newError("Unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
@@ -299,6 +738,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.RANGE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalRangeIndexExpression(left, index)
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -306,6 +747,15 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	}
 }
 
+func evalRangeIndexExpression(rng, index object.Object) object.Object {
+	rangeObject := rng.(*object.Range)
+	idx := index.(*object.Integer).Value
+	if idx < 0 || idx >= rangeObject.Len() {
+		return NULL
+	}
+	return object.NewInteger(rangeObject.Start + idx)
+}
+
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
 	idx := index.(*object.Integer).Value
@@ -319,7 +769,18 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
-	for keyNode, valueNode := range node.Pairs {
+	for _, keyNode := range node.Entries {
+		if spreadNode, ok := keyNode.(*ast.SpreadExpression); ok {
+			spread := Eval(spreadNode.Value, env)
+			if isError(spread) {
+				return spread
+			}
+			if err := mergeHash(pairs, spread); err != nil {
+				return err
+			}
+			continue
+		}
+
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
@@ -330,7 +791,7 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return newError("Unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := Eval(node.Pairs[keyNode], env)
 		if isError(value) {
 			return value
 		}
@@ -342,6 +803,38 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 	return &object.Hash{Pairs: pairs}
 }
 
+func mergeHash(pairs map[object.HashKey]object.HashPair, value object.Object) object.Object {
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return newError("Cannot spread %s into HASH", value.Type())
+	}
+
+	for hashed, pair := range hash.Pairs {
+		pairs[hashed] = pair
+	}
+	return nil
+}
+
+func evalSetLiteral(node *ast.SetLiteral, env *object.Environment) object.Object {
+	set := object.NewSet()
+
+	for _, elementNode := range node.Elements {
+		element := Eval(elementNode, env)
+		if isError(element) {
+			return element
+		}
+
+		hashKey, ok := element.(object.Hashable)
+		if !ok {
+			return newError("Unusable as set element: %s", element.Type())
+		}
+
+		set.Add(hashKey.HashKey(), element)
+	}
+
+	return set
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
@@ -358,6 +851,184 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
+func newStruct(def *object.StructType, args []object.Object) object.Object {
+	if len(args) != len(def.Fields) {
+		return newError("Wrong number of arguments to %s. Got %d, expected %d",
+			def.Name, len(args), len(def.Fields))
+	}
+
+	fields := make(map[string]object.Object, len(args))
+	for i, name := range def.Fields {
+		fields[name] = args[i]
+	}
+
+	return &object.Struct{Definition: def, Fields: fields}
+}
+
+func evalDotExpression(left object.Object, name string) object.Object {
+	switch left := left.(type) {
+	case *object.Struct:
+		value, ok := left.Fields[name]
+		if !ok {
+			return newError("Unknown field %s on %s", name, left.Definition.Name)
+		}
+		return value
+	case *object.Instance:
+		value, ok := left.Fields[name]
+		if !ok {
+			return newError("Unknown field %s on %s", name, left.Class.Name)
+		}
+		return value
+	default:
+		return newError("Dot access not supported: %s", left.Type())
+	}
+}
+
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	target, ok := node.Target.(*ast.DotExpression)
+	if !ok {
+		return newError("Invalid assignment target: %s", node.Target.String())
+	}
+
+	left := Eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	value := Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+
+	return setField(left, target.Field.Value, value)
+}
+
+func setField(obj object.Object, name string, value object.Object) object.Object {
+	switch obj := obj.(type) {
+	case *object.Struct:
+		if !obj.Definition.HasField(name) {
+			return newError("Unknown field %s on %s", name, obj.Definition.Name)
+		}
+		obj.Fields[name] = value
+		return value
+	case *object.Instance:
+		obj.Fields[name] = value
+		return value
+	default:
+		return newError("Field assignment not supported: %s", obj.Type())
+	}
+}
+
+func evalClassStatement(node *ast.ClassStatement, env *object.Environment) object.Object {
+	class := &object.Class{
+		Name:    node.Name.Value,
+		Methods: make(map[string]object.Object, len(node.Methods)),
+	}
+
+	if node.Superclass != nil {
+		superclass := evalIdentifier(node.Superclass, env)
+		if isError(superclass) {
+			return superclass
+		}
+		parent, ok := superclass.(*object.Class)
+		if !ok {
+			return newError("Superclass must be a class, got %s", superclass.Type())
+		}
+		class.Superclass = parent
+	}
+
+	for _, method := range node.Methods {
+		class.Methods[method.Name] = &object.Function{
+			Name:       method.Name,
+			Parameters: method.Parameters,
+			Patterns:   method.Patterns,
+			Body:       method.Body,
+			Env:        env,
+		}
+	}
+
+	return class
+}
+
+func newInstance(class *object.Class, args []object.Object) object.Object {
+	instance := &object.Instance{Class: class, Fields: make(map[string]object.Object)}
+
+	initializer, definedIn := class.FindMethod("init")
+	if initializer == nil {
+		if len(args) != 0 {
+			return newError("Wrong number of arguments to %s. Got %d, expected 0",
+				class.Name, len(args))
+		}
+		return instance
+	}
+
+	result := applyMethod(instance, definedIn, initializer.(*object.Function), args)
+	if isError(result) {
+		return result
+	}
+
+	return instance
+}
+
+func evalMethodCall(env *object.Environment, receiver object.Object, name string, args []object.Object) object.Object {
+	switch receiver := receiver.(type) {
+	case *object.Instance:
+		if field, ok := receiver.Fields[name]; ok {
+			return applyFunction(env, field, args)
+		}
+		method, definedIn := receiver.Class.FindMethod(name)
+		if method == nil {
+			return newError("Undefined method %s on %s", name, receiver.Class.Name)
+		}
+		return applyMethod(receiver, definedIn, method.(*object.Function), args)
+	case *object.Super:
+		method, definedIn := receiver.Class.FindMethod(name)
+		if method == nil {
+			return newError("Undefined method %s on %s", name, receiver.Class.Name)
+		}
+		return applyMethod(receiver.Self, definedIn, method.(*object.Function), args)
+	case *object.Struct:
+		field, ok := receiver.Fields[name]
+		if !ok {
+			return newError("Unknown field %s on %s", name, receiver.Definition.Name)
+		}
+		return applyFunction(env, field, args)
+	default:
+		return applyBuiltinMethod(env, receiver, name, args)
+	}
+}
+
+// applyMethod calls method with self bound to the receiver and, when the
+// defining class has a parent, super bound to that parent.
+func applyMethod(self *object.Instance, definedIn *object.Class, method *object.Function, args []object.Object) object.Object {
+	if len(args) != len(method.Parameters) {
+		return newError("Wrong number of arguments to %s.%s. Got %d, expected %d",
+			definedIn.Name, method.Name, len(args), len(method.Parameters))
+	}
+	if err := step(method.Env); err != nil {
+		return err
+	}
+	leave, err := enter(method.Env)
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	env := object.NewEnclosedEnvironment(method.Env)
+	env.Set("self", self)
+	if definedIn.Superclass != nil {
+		env.Set("super", &object.Super{Self: self, Class: definedIn.Superclass})
+	}
+
+	if err := bindParameters(env, method, args); err != nil {
+		return err
+	}
+
+	evaluated := Eval(method.Body, env)
+	addTraceFrame(evaluated, definedIn.Name+"."+method.Name)
+	return unwrapReturnValue(evaluated)
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -379,18 +1050,262 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return Eval(ie.Consequence, blockEnvironment(env))
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return Eval(ie.Alternative, blockEnvironment(env))
 	} else {
 		return NULL
 	}
 }
 
+func evalTernaryExpression(te *ast.TernaryExpression, env *object.Environment) object.Object {
+	condition := Eval(te.Condition, env)
+	if isError(condition) {
+		return condition
+	}
+
+	if isTruthy(condition) {
+		return Eval(te.Consequence, env)
+	}
+	return Eval(te.Alternative, env)
+}
+
+// evalMatchExpression runs the first arm whose pattern matches the subject
+// and whose guard, if any, is truthy. Each arm gets its own environment
+// holding the names its pattern binds. With no matching arm the result is null.
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		armEnv := object.NewEnclosedEnvironment(env)
+
+		matched, err := matchPattern(arm.Pattern, subject, armEnv)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return Eval(arm.Body, armEnv)
+	}
+
+	return NULL
+}
+
+// matchPattern reports whether value has the shape of pattern, binding
+// pattern identifiers in env as it goes:
+//
+//	_             matches anything
+//	name          matches anything and binds it
+//	[a, ...rest]  matches arrays element-wise, rest taking the remainder
+//	{kind: "x"}   matches hashes, structs and instances by key or field
+//	integer(n)    matches when tp(value) is "integer", then matches n
+//
+// Any other expression is evaluated and compared for equality.
+func matchPattern(pattern ast.Expression, value object.Object, env *object.Environment) (bool, object.Object) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			if err := asError(bind(env, pattern, value, false)); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case *ast.ArrayLiteral:
+		return matchArrayPattern(pattern, value, env)
+	case *ast.HashLiteral:
+		return matchHashPattern(pattern, value, env)
+	case *ast.CallExpression:
+		if name, ok := pattern.Function.(*ast.Identifier); ok {
+			return matchTypePattern(name.Value, pattern.Arguments, value, env)
+		}
+	}
+
+	expected := Eval(pattern, env)
+	if isError(expected) {
+		return false, expected
+	}
+	return isEqual(expected, value), nil
+}
+
+func matchArrayPattern(pattern *ast.ArrayLiteral, value object.Object, env *object.Environment) (bool, object.Object) {
+	arr, ok := value.(*object.Array)
+	if !ok {
+		return false, nil
+	}
+
+	elements := pattern.Elements
+	var rest *ast.Identifier
+	if n := len(elements); n > 0 {
+		if spread, ok := elements[n-1].(*ast.SpreadExpression); ok {
+			rest, ok = spread.Value.(*ast.Identifier)
+			if !ok {
+				return false, newError("Invalid rest pattern: %s", spread.String())
+			}
+			elements = elements[:n-1]
+		}
+	}
+
+	if len(arr.Elements) < len(elements) || (rest == nil && len(arr.Elements) > len(elements)) {
+		return false, nil
+	}
+
+	for i, el := range elements {
+		matched, err := matchPattern(el, arr.Elements[i], env)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	if rest != nil {
+		remaining := make([]object.Object, len(arr.Elements)-len(elements))
+		copy(remaining, arr.Elements[len(elements):])
+		return matchPattern(rest, &object.Array{Elements: remaining}, env)
+	}
+
+	return true, nil
+}
+
+func matchHashPattern(pattern *ast.HashLiteral, value object.Object, env *object.Environment) (bool, object.Object) {
+	for _, keyNode := range pattern.Entries {
+		valuePattern := pattern.Pairs[keyNode]
+		var key object.Object
+		if ident, ok := keyNode.(*ast.Identifier); ok {
+			key = &object.String{Value: ident.Value}
+		} else {
+			key = Eval(keyNode, env)
+			if isError(key) {
+				return false, key
+			}
+		}
+
+		field, ok := patternField(value, key)
+		if !ok {
+			return false, nil
+		}
+
+		matched, err := matchPattern(valuePattern, field, env)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func patternField(value, key object.Object) (object.Object, bool) {
+	switch value := value.(type) {
+	case *object.Hash:
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, false
+		}
+		pair, ok := value.Pairs[hashKey.HashKey()]
+		return pair.Value, ok
+	case *object.Struct:
+		name, ok := key.(*object.String)
+		if !ok {
+			return nil, false
+		}
+		field, ok := value.Fields[name.Value]
+		return field, ok
+	case *object.Instance:
+		name, ok := key.(*object.String)
+		if !ok {
+			return nil, false
+		}
+		field, ok := value.Fields[name.Value]
+		return field, ok
+	default:
+		return nil, false
+	}
+}
+
+func matchTypePattern(name string, args []ast.Expression, value object.Object, env *object.Environment) (bool, object.Object) {
+	if len(args) > 1 {
+		return false, newError("Type pattern %s takes at most 1 argument, got %d", name, len(args))
+	}
+
+	if !hasTypeName(value, name) {
+		return false, nil
+	}
+
+	if len(args) == 0 {
+		return true, nil
+	}
+
+	return matchPattern(args[0], value, env)
+}
+
+// hasTypeName reports whether tp(value) is name or, for instances, whether
+// any class it inherits from is called name.
+func hasTypeName(value object.Object, name string) bool {
+	if typeName(value) == name {
+		return true
+	}
+	if instance, ok := value.(*object.Instance); ok {
+		for class := instance.Class; class != nil; class = class.Superclass {
+			if class.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bind declares ident in env, in the slot the resolver gave it if any.
+func bind(env *object.Environment, ident *ast.Identifier, val object.Object, constant bool) object.Object {
+	if ident.Resolved {
+		return env.SetAt(ident.Slot, val)
+	}
+	if constant {
+		return env.SetConst(ident.Value, val)
+	}
+	return env.Set(ident.Value, val)
+}
+
+// blockEnvironment returns the environment an if/el body runs in, which is
+// env itself if env's runtime has block scoping turned off.
+func blockEnvironment(env *object.Environment) *object.Environment {
+	if runtime := env.Runtime(); runtime != nil && !runtime.BlockScoping() {
+		return env
+	}
+	return object.NewEnclosedEnvironment(env)
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+func addTraceFrame(obj object.Object, frame string) {
+	if err, ok := obj.(*object.Error); ok {
+		err.Trace = append(err.Trace, frame)
+	}
+}
+
+// asError returns obj if it is an error and nil otherwise, for statements
+// whose only possible result is a failure.
+func asError(obj object.Object) object.Object {
+	if isError(obj) {
+		return obj
+	}
+	return nil
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
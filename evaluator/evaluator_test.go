@@ -1,10 +1,16 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"squ1d/ast"
 	"squ1d/lexer"
 	"squ1d/object"
 	"squ1d/parser"
+	"squ1d/resolver"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -213,6 +219,327 @@ func TestHashIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestSetLiterals(t *testing.T) {
+	input := `var two = "two"; {1, two, 1, true, "t" + "wo"}`
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Set)
+	if !ok {
+		t.Fatalf("Eval did not return Set. Got %T (%+v)", evaluated, evaluated)
+	}
+
+	if result.Inspect() != "{1, two, true}" {
+		t.Errorf("Set has wrong elements. Got %s", result.Inspect())
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`2 in {1, 2, 3}`, true},
+		{`4 in {1, 2, 3}`, false},
+		{`"a" in set(["a", "b"])`, true},
+		{`cat({1, 2, 2, 3})`, 3},
+		{`cat(set())`, 0},
+		{`tp({1})`, "set"},
+		{`union({1, 2}, {2, 3}) == {1, 2, 3}`, true},
+		{`inter({1, 2}, {2, 3}) == {2}`, true},
+		{`diff({1, 2}, {2, 3}) == {1}`, true},
+		{`{1, 2} == {2, 1}`, true},
+		{`{1, 2} == {1}`, false},
+		{`elems({3, 1, 2})[1]`, 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("Object is not String. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. Got %q, expected %q", str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestInOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`2 in [1, 2, 3]`, true},
+		{`4 in [1, 2, 3]`, false},
+		{`"b" in ["a", "b"]`, true},
+		{`"2" in [1, 2]`, false},
+		{`"foo" in {"foo": 1}`, true},
+		{`"bar" in {"foo": 1}`, false},
+		{`true in {true: 1}`, true},
+		{`"ell" in "hello"`, true},
+		{`"" in "hello"`, true},
+		{`"xyz" in "hello"`, false},
+		{`4 not in [1, 2, 3]`, true},
+		{`2 not in [1, 2, 3]`, false},
+		{`"foo" not in {"foo": 1}`, false},
+		{`"x" not in "abc"`, true},
+		{`1 + 1 in [2] == true`, true},
+		{`[1] in [[1]]`, true},
+		{`[1] in [[1, 2]]`, false},
+		{`[1] not in [[2], [1]]`, false},
+		{`{"a": [1]} in [{"a": [1]}]`, true},
+		{`{"a": 1} in [{"a": 2}]`, false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"struct Point { x, y }; var p = Point(1, 2); p.x + p.y", 3},
+		{"struct Point { x, y }; var p = Point(1, 2); p.x = 10; p.x", 10},
+		{"struct Point { x, y }; var p = Point(1, 2); p.y = p.x = 5; p.x + p.y", 10},
+		{"struct Box { v }; var a = Box(1); var b = a; b.v = 7; a.v", 7},
+		{"struct Pair { a, b }; Pair(Pair(1, 2), 3).a.b", 2},
+		{"struct Point { x, y }; tp(Point(1, 2))", "Point"},
+		{"struct Point { x, y }; tp(Point)", "struct"},
+		{"struct Point { x, y }; Point(1, 2) == Point(1, 2)", true},
+		{"struct Point { x, y }; Point(1, 2) == Point(2, 1)", false},
+		{"struct P { x }; var p = P(1); p.x = p; var q = P(1); q.x = q; p == q", true},
+		{"struct P { x, y }; var p = P(1, 1); p.x = p; var q = P(1, 2); q.x = q; p == q", false},
+		{"struct P { x }; var p = P(1); p.x = [p]; var q = P(1); q.x = [q]; [p] in [[q]]", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("Object is not String. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. Got %q, expected %q", str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestStructInspect(t *testing.T) {
+	input := `struct User { name, age }; User("ann", 30)`
+
+	evaluated := testEval(input)
+	if evaluated.Inspect() != "User{name: ann, age: 30}" {
+		t.Errorf("Inspect has wrong value. Got %q", evaluated.Inspect())
+	}
+}
+
+func TestCyclicStructInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"struct P { x }; var p = P(1); p.x = p; p", "P{x: ...}"},
+		{"struct P { x, y }; var p = P(1, 2); p.x = [p, P(3, 4)]; p", "P{x: [..., P{x: 3, y: 4}], y: 2}"},
+		{"struct P { x }; var p = P(1); p.x = p; [p, p]", "[P{x: ...}, P{x: ...}]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("Inspect has wrong value. Got %q, expected %q", evaluated.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestClasses(t *testing.T) {
+	animals := `
+class Animal {
+	def init(name) { self.name = name; }
+	def speak() { self.name + " makes a sound" }
+	def rename(name) { self.name = name; self }
+}
+
+class Dog : Animal {
+	def init(name) { super.init(name); self.tricks = 0; }
+	def speak() { super.speak() + " (woof)" }
+	def learn() { self.tricks = self.tricks + 1 }
+}
+
+class Puppy : Dog {
+	def speak() { "small " + super.speak() }
+}
+`
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`Animal("cat").speak()`, "cat makes a sound"},
+		{`Dog("rex").speak()`, "rex makes a sound (woof)"},
+		{`Puppy("bit").speak()`, "small bit makes a sound (woof)"},
+		{`var d = Dog("rex"); d.learn(); d.learn(); d.tricks`, 2},
+		{`Dog("rex").rename("max").speak()`, "max makes a sound (woof)"},
+		{`Dog("rex").name`, "rex"},
+		{`tp(Puppy("bit"))`, "Puppy"},
+		{`tp(Dog)`, "class"},
+		{`var a = Animal("x"); var b = a; b.name = "y"; a.name`, "y"},
+		{`var a = Animal("x"); a.f = def(n) { n * 2 }; a.f(21)`, 42},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(animals + tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("Object is not String. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. Got %q, expected %q", str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestClassClosures(t *testing.T) {
+	input := `
+var makeCounter = def(start) {
+	class Counter {
+		def init() { self.n = start; }
+		def next() { self.n = self.n + 1 }
+	}
+	Counter()
+};
+var c = makeCounter(10);
+c.next();
+c.next();`
+
+	testIntegerObject(t, testEval(input), 12)
+}
+
+func TestBuiltinMethods(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"hello".len()`, 5},
+		{`"42".int() + 1`, 43},
+		{`"a b c".field(1)`, "b"},
+		{`[1, 2, 3].len()`, 3},
+		{`[1, 2, 3].first()`, 1},
+		{`[1, 2, 3].last()`, 3},
+		{`[1, 2].push(3).last()`, 3},
+		{`var a = [1]; a.push(2); a.len()`, 1},
+		{`[1, 1, 2].set().len()`, 2},
+		{`{"a": 1}.keys()[0]`, "a"},
+		{`{"a": 1}.values()[0]`, 1},
+		{`{"a": 1, "b": 2}.len()`, 2},
+		{`{1, 2}.union({3}).len()`, 3},
+		{`{1, 2}.inter({2}).elems()[0]`, 2},
+		{`{1, 2}.diff({2}).elems()[0]`, 1},
+		{`keys({"a": 1}).len()`, 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("Object is not String. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. Got %q, expected %q", str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestHashKeysOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{3: "c", -1: "a", 2: "b"}.keys()`, "[-1, 2, 3]"},
+		{`{3: "c", -1: "a", 2: "b"}.values()`, "[a, b, c]"},
+		{`{true: 1, false: 0}.keys()`, "[false, true]"},
+		{`{"apple": 1, "banana": 2, "cherry": 3, "date": 4}.keys()`, "[apple, banana, cherry, date]"},
+		{`{"date": 4, "apple": 1, "cherry": 3, "banana": 2}.values()`, "[1, 2, 3, 4]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong order. Got %s, expected %s", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+
+	input := `var h = {"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}; [h.keys(), h.values()]`
+	first := testEval(input).Inspect()
+	for i := 0; i < 20; i++ {
+		if got := testEval(input).Inspect(); got != first {
+			t.Fatalf("keys and values changed order. Got %s, then %s", first, got)
+		}
+	}
+	pairs := testEval(input).(*object.Array).Elements
+	keys, values := pairs[0].(*object.Array).Elements, pairs[1].(*object.Array).Elements
+	for i, key := range keys {
+		want := int64(key.(*object.String).Value[0]-'a') + 1
+		testIntegerObject(t, values[i], want)
+	}
+}
+
+func TestPipelineOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"hello" |> cat`, 5},
+		{`[1, 2] |> add(3) |> last`, 3},
+		{`var double = def(x) { x * 2 }; 5 |> double |> double`, 20},
+		{`var sub = def(a, b) { a - b }; 10 |> sub(3)`, 7},
+		{`"a b" |> sepr(1) |> cat`, 1},
+		{`([1] |> add(2) |> cat) == 2`, true},
+		{`(1 == 1 |> tp) == "boolean"`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -282,6 +609,195 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 }
 
+func TestTernaryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true ? 10 : 20", 10},
+		{"false ? 10 : 20", 20},
+		{"1 < 2 ? 10 : 20", 10},
+		{"1 > 2 ? 10 : 1 > 0 ? 20 : 30", 20},
+		{"var x = 0 ? 1 : 2; x", 1},
+		{"var f = def(n) { n < 2 ? n : f(n - 1) + f(n - 2) }; f(10)", 55},
+		{"true ? 1 : missing", 1},
+		{"false ? missing : 2", 2},
+		{`{"a": false ? 1 : 2}["a"]`, 2},
+		{"missing ? 1 : 2", "Identifier not found: missing"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. Expected %q, got %q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestRanges(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"cat(1..5)", 4},
+		{"cat(1..=5)", 5},
+		{"cat(5..1)", 0},
+		{"cat(0..1000000000000)", 1000000000000},
+		{"(10..20)[3]", 13},
+		{"(10..20)[10]", nil},
+		{"(10..=20)[10]", 20},
+		{"(10..20)[-1]", nil},
+		{"var n = 3; (0..n * 2)[5]", 5},
+		{"3 in 1..3", false},
+		{"3 in 1..=3", true},
+		{"0 in 1..3", false},
+		{`"a" in 1..3`, false},
+		{"2 not in 0..2", true},
+		{"cat([...0..4])", 4},
+		{"var add = def(a, b, c) { a + b + c }; add(...1..=3)", 6},
+		{"cat(elems(2..4))", 2},
+		{"first(3..9)", 3},
+		{"last(3..9)", 8},
+		{"last(3..=9)", 9},
+		{"first(9..3)", nil},
+		{"(0..7).len()", 7},
+		{"(0..7).last()", 6},
+		{"1..3 == 1..=2", true},
+		{"1..3 == 1..4", false},
+		{"5..1 == 9..2", true},
+		{"0..1000000000000 |> last", 999999999999},
+		{`"a".."b"`, "Unknown operator: STRING .. STRING"},
+		{`1.."b"`, "Type mismatch: INTEGER .. STRING"},
+		{"(0..3)[true]", "Index operator not supported: RANGE"},
+		{"(0..9223372036854775807).len()", 9223372036854775807},
+		{"(-9223372036854775807..0).len()", 9223372036854775807},
+		{"(-9223372036854775807 - 1..-1)[9223372036854775806]", -2},
+		{"0..=9223372036854775807", "Range too long: 0..=9223372036854775807"},
+		{"-1..9223372036854775807", "Range too long: -1..9223372036854775807"},
+		{"-9223372036854775807 - 1..9223372036854775807",
+			"Range too long: -9223372036854775808..9223372036854775807"},
+		{"cat(9223372036854775807..-9223372036854775807 - 1)", 0},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. Expected %q, got %q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestRangeInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1..5", "1..5"},
+		{"0..=n", "0..=3"},
+		{"tp(1..2)", "range"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval("var n = 3; " + tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestBlockScoping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// Bindings made inside an if or el body stay inside it.
+		{"var x = 1; if (true) { var x = 2; }; x", 1},
+		{"var x = 1; if (false) { 0 } el { var x = 3; }; x", 1},
+		{"if (true) { var y = 2; }; y", "Identifier not found: y"},
+		// Blocks still see, and can shadow, outer bindings.
+		{"var x = 1; if (true) { x + 1 }", 2},
+		{"var x = 1; if (true) { var x = x + 10; x }", 11},
+		{"var x = 1; if (true) { if (true) { var x = 5; }; x }", 1},
+		// A block may shadow a constant from an enclosing scope.
+		{"const c = 1; if (true) { var c = 2; c }", 2},
+		// Functions declared in a block are hoisted within that block only.
+		{"if (true) { var r = f(); def f() { 4 }; r }", 4},
+		{"if (true) { def f() { 4 } }; f()", "Identifier not found: f"},
+		// Closures created in a block keep the block's bindings alive.
+		{"var g = if (true) { var n = 9; def() { n } }; g()", 9},
+		// Function bodies are scoped as before.
+		{"var f = def() { var z = 1; z }; f(); z", "Identifier not found: z"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. Expected %q, got %q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestBlockScopingDisabled(t *testing.T) {
+	testEval := func(input string) object.Object {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		r := resolver.New(BuiltinNames()...)
+		r.BlockScoping = false
+		r.Resolve(program)
+
+		runtime := &object.Runtime{}
+		runtime.SetBlockScoping(false)
+		env := object.NewEnvironment()
+		env.SetRuntime(runtime)
+		return Eval(program, env)
+	}
+
+	testIntegerObject(t, testEval("var x = 1; if (true) { var x = 2; }; x"), 2)
+	testIntegerObject(t, testEval("if (true) { var y = 3; }; y"), 3)
+
+	evaluated := testEval("const c = 1; if (true) { var c = 2; }")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "Cannot reassign constant: c" {
+		t.Errorf("Wrong error message. Got %q", errObj.Message)
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "def(x) { x + 2 };"
 
@@ -306,21 +822,92 @@ func TestFunctionObject(t *testing.T) {
 	}
 }
 
-func TestFunctionApplication(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected int64
-	}{
-		{"var identity = def(x) { x; }; identity(5);", 5},
-		{"var identity = def(x) { return x; }; identity(5);", 5},
-		{"var double = def(x) { x * 2; }; double(5);", 10},
-		{"var add = def(x, y) { x + y; }; add(5, 5);", 10},
-		{"var add = def(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
-		{"def(x) { x; }(5)", 5},
+func TestFunctionApplication(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"var identity = def(x) { x; }; identity(5);", 5},
+		{"var identity = def(x) { return x; }; identity(5);", 5},
+		{"var double = def(x) { x * 2; }; double(5);", 10},
+		{"var add = def(x, y) { x + y; }; add(5, 5);", 10},
+		{"var add = def(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+		{"def(x) { x; }(5)", 5},
+		{"var double = (x) => x * 2; double(5);", 10},
+		{"var add = (x, y) => x + y; add(5, 5);", 10},
+		{"var five = () => 5; five();", 5},
+		{"var f = (x) => { var y = x * 2; return y + 1; }; f(2);", 5},
+		{"((x) => x + 1)(4)", 5},
+		{"var adder = (x) => (y) => x + y; adder(2)(3);", 5},
+		{"[1, 2, 3] |> ((a) => last(a))", 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"def add(x, y) { x + y }; add(2, 3);", 5},
+		{"var r = double(4); def double(x) { x * 2 }; r;", 8},
+		{"def isEven(n) { if (n == 0) { true } el { isOdd(n - 1) } } def isOdd(n) { if (n == 0) { false } el { isEven(n - 1) } } if (isEven(10)) { 1 } el { 0 }", 1},
+		{"def outer() { return inner(); def inner() { 7 } } outer();", 7},
+		{"var base = 10; def addBase(x) { x + base }; addBase(5);", 15},
+		{"def counter(start) { def next() { start + 1 } next } counter(41)();", 42},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestFunctionDeclarationInspect(t *testing.T) {
+	evaluated := testEval("def square(x) { x * x } square")
+
+	fn, ok := evaluated.(*object.Function)
+	if !ok {
+		t.Fatalf("Object is not Function. Got %T (%+v)", evaluated, evaluated)
+	}
+
+	if fn.Name != "square" {
+		t.Errorf("Function has wrong name. Got %q", fn.Name)
+	}
+
+	expected := "fn square(x) {\n(x * x)\n}"
+	if fn.Inspect() != expected {
+		t.Errorf("Inspect has wrong value. Expected %q, got %q", expected, fn.Inspect())
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	input := `
+class Shape { def area() { fail() } }
+def fail() { 1 + true }
+def outer() { var helper = def() { Shape().area() }; helper() }
+outer()`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+	}
+
+	expected := []string{"fail", "Shape.area", "outer"}
+	if len(errObj.Trace) != len(expected) {
+		t.Fatalf("Trace has wrong length. Expected %v, got %v", expected, errObj.Trace)
+	}
+	for i, frame := range expected {
+		if errObj.Trace[i] != frame {
+			t.Errorf("Trace[%d] is wrong. Expected %q, got %q", i, frame, errObj.Trace[i])
+		}
 	}
 
-	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+	if errObj.Inspect() != "ERROR: Type mismatch: INTEGER + BOOLEAN\n\tin fail\n\tin Shape.area\n\tin outer" {
+		t.Errorf("Inspect has wrong value. Got %q", errObj.Inspect())
 	}
 }
 
@@ -456,6 +1043,94 @@ func TestErrorHandling(t *testing.T) {
 			`{"name": "Monkey"}[def(x) { x }];`,
 			"Unusable as hash key: FUNCTION",
 		},
+		{
+			"struct Point { x, y }; Point(1)",
+			"Wrong number of arguments to Point. Got 1, expected 2",
+		},
+		{
+			"struct Point { x, y }; Point(1, 2).z",
+			"Unknown field z on Point",
+		},
+		{
+			"struct Point { x, y }; var p = Point(1, 2); p.z = 3",
+			"Unknown field z on Point",
+		},
+		{
+			"[1].x",
+			"Dot access not supported: ARRAY",
+		},
+		{
+			"5.len()",
+			"Undefined method len on INTEGER",
+		},
+		{
+			`"abc".push(1)`,
+			"Undefined method push on STRING",
+		},
+		{
+			"[1].first(2)",
+			"Wrong number of arguments. Got 2, expected 1",
+		},
+		{
+			"class A { def f(x) { x } }; A().f()",
+			"Wrong number of arguments to A.f. Got 0, expected 1",
+		},
+		{
+			"class A { }; A(1)",
+			"Wrong number of arguments to A. Got 1, expected 0",
+		},
+		{
+			"class A { }; A().g()",
+			"Undefined method g on A",
+		},
+		{
+			"class A { def f() { super.f() } }; A().f()",
+			"Identifier not found: super",
+		},
+		{
+			"var B = 1; class A : B { }",
+			"Superclass must be a class, got INTEGER",
+		},
+		{
+			`1 in "abc"`,
+			"Type mismatch: INTEGER in STRING",
+		},
+		{
+			`1 not in 2`,
+			"Unknown operator: INTEGER not in INTEGER",
+		},
+		{
+			`1 not in "abc"`,
+			"Type mismatch: INTEGER not in STRING",
+		},
+		{
+			`[1] in {"a": 1}`,
+			"Unusable as hash key: ARRAY",
+		},
+		{
+			`{1, [2]}`,
+			"Unusable as set element: ARRAY",
+		},
+		{
+			`union({1}, [2])`,
+			"Arguments to `union` must be SET, got SET and ARRAY",
+		},
+		{
+			"var a = 0; 1 / a",
+			"Division by zero",
+		},
+		{
+			"def f(x) { x }; f()",
+			"Wrong number of arguments to f. Got 0, expected 1",
+		},
+		{
+			"def(x) { x }(1, 2)",
+			"Wrong number of arguments. Got 2, expected 1",
+		},
+		{
+			"def f([a, b]) { a }; f()",
+			"Wrong number of arguments to f. Got 0, expected 1",
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -491,10 +1166,14 @@ func TestBangOperator(t *testing.T) {
 	}
 }
 
+// testEval resolves input before evaluating it but ignores resolver errors,
+// so unresolved names still surface as runtime errors.
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+	r := resolver.New(BuiltinNames()...)
+	r.Resolve(program)
 	env := object.NewEnvironment()
 
 	return Eval(program, env)
@@ -512,6 +1191,213 @@ addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"var [a, b] = [1, 2]; a * 10 + b", 12},
+		{"var [a, ...rest] = [1, 2, 3]; cat(rest) * 10 + last(rest)", 23},
+		{"var [a, ...rest] = [1]; cat(rest)", 0},
+		{"var [...all] = [4, 5]; first(all)", 4},
+		{`var {name, age} = {"name": "ann", "age": 30}; age`, 30},
+		{`var {x} = {"x": 1, "y": 2}; x`, 1},
+		{"struct P { x, y }; var {x, y} = P(3, 4); x + y", 7},
+		{"var f = def([a, b], c) { a + b + c }; f([1, 2], 3)", 6},
+		{`def area({w, h}) { w * h } area({"w": 3, "h": 5})`, 15},
+		{"var minmax = def() { [1, 9] }; var [lo, hi] = minmax(); hi - lo", 8},
+		{"const [a, b] = [1, 2]; var f = def() { var a = 5; a }; f() + b", 7},
+		{"var [a, b] = [1]", "Cannot destructure ARRAY of length 1 into 2 elements"},
+		{"var [a] = [1, 2]", "Cannot destructure ARRAY of length 2 into 1 elements"},
+		{"var [a] = 5", "Cannot destructure INTEGER as ARRAY"},
+		{`var {a} = {"b": 1}`, `Cannot destructure HASH: missing key "a"`},
+		{"var {a} = [1]", "Cannot destructure ARRAY as HASH"},
+		{"struct P { x }; var {y} = P(1)", "Unknown field y on P"},
+		{"def f([a, b]) { a } f([1])", "Cannot destructure ARRAY of length 1 into 2 elements"},
+		{"const [a] = [1]; const [a] = [2]", "Cannot reassign constant: a"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. Expected %q, got %q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestSpreadExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"var add = def(a, b, c) { a + b + c }; add(...[1, 2, 3])", 6},
+		{"var add = def(a, b, c) { a + b + c }; add(1, ...[2, 3])", 6},
+		{"var add = def(a, b, c) { a + b + c }; var xs = [2]; add(1, ...xs, 3)", 6},
+		{"cat([0, ...[1, 2], ...[], 3])", 4},
+		{"[0, ...[1, 2], 3][2]", 2},
+		{"[...{7}][0]", 7},
+		{"var xs = [1, 2]; var ys = [...xs, 3]; cat(xs) * 10 + cat(ys)", 23},
+		{"[1, 2].push(...[3])[2]", 3},
+		{`var d = {"a": 1, "b": 2}; var o = {...d, "b": 3}; o["a"] * 10 + o["b"]`, 13},
+		{`var o = {"b": 3, ...{"b": 2}}; o["b"]`, 2},
+		{`var h = {"a": 2}; {"a": 1, ...h, "b": 3}["a"]`, 2},
+		{`var h = {"a": 2}; {...h, "a": 1}["a"]`, 1},
+		{`{...{"a": 1}, ...{"a": 2}}["a"]`, 2},
+		{`cat(keys({...{"a": 1}, ...{"b": 2}}))`, 2},
+		{"[...5]", "Cannot spread INTEGER"},
+		{"{...[1]}", "Cannot spread ARRAY into HASH"},
+		{"[...missing]", "Identifier not found: missing"},
+		{"[...0..100000000000]", "Range too long to list: 0..100000000000"},
+		{"elems(0..4611686018427387904)", "Range too long to list: 0..4611686018427387904"},
+		{"var f = def(a) { a }; f(...0..100000000000)", "Range too long to list: 0..100000000000"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. Expected %q, got %q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"match (2) { 1 => 10, 2 => 20, _ => 30 }", 20},
+		{"match (5) { 1 => 10, _ => 30 }", 30},
+		{"match (5) { 1 => 10 }", nil},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{"match (-1) { -1 => 1, _ => 2 }", 1},
+		{"match (true) { false => 1, true => 2 }", 2},
+		{"match (7) { n => n * 2 }", 14},
+		{"match (7) { n if n > 10 => 1, n if n > 5 => 2, _ => 3 }", 2},
+		{"match (7) { integer(n) => n + 1, _ => 0 }", 8},
+		{`match ("s") { integer() => 1, string() => 2 }`, 2},
+		{"match ([1, 2, 3]) { [] => 0, [a] => a, [a, b, c] => a + b + c }", 6},
+		{"match ([1, 2]) { [1, 2, 3] => 1, _ => 2 }", 2},
+		{"match ([1, 2, 3]) { [first, ...rest] => first * 10 + cat(rest) }", 12},
+		{"match ([1]) { [_, ...rest] => cat(rest) }", 0},
+		{"match ([[1, 2], 3]) { [[a, b], c] => a + b + c }", 6},
+		{"match (5) { [a] => a, _ => 0 }", 0},
+		{`match ({"kind": "circle", "r": 2}) { {"kind": "square"} => 1, {"kind": "circle", "r": r} => r * 3 }`, 6},
+		{`match ({"a": 1}) { {b: x} => x, _ => 9 }`, 9},
+		{"struct P { x, y }; match (P(1, 2)) { P({x: 1, y: y}) => y, _ => 0 }", 2},
+		{"struct P { x, y }; match (P(1, 2)) { {x: x} => x }", 1},
+		{"class A { }; class B : A { }; match (B()) { A() => 1, _ => 2 }", 1},
+		{"class A { }; match (A()) { B() => 1, A() => 2 }", 2},
+		{"var n = 3; match (3) { n => 1 }; n", 3},
+		{"var k = 3; match (3) { k + 0 => 1, _ => 2 }", 1},
+		{"match (1) { 1 => { var a = 2; a * 3 } }", 6},
+		{"var f = def(x) { match (x) { 0 => { return 1 }, _ => 2 }; 3 }; f(0)", 1},
+		{"match (1) { 1 => missing }", "Identifier not found: missing"},
+		{"match (1) { integer(a, b) => 1 }", "Type pattern integer takes at most 1 argument, got 2"},
+		{"match ([1]) { [...1] => 1 }", "Invalid rest pattern: ...1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("Wrong error message. Expected %q, got %q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestResolvedLocals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"var f = def(a) { var b = a * 2; def() { a + b } }; f(3)()", 9},
+		{"def f(n) { if (n > 0) { var m = n - 1; n + f(m) } el { 0 } } f(10)", 55},
+		{"def f() { var h = def() { y }; var y = 2; h() } f()", 2},
+		// A local read before it is assigned falls back to the global.
+		{"var x = 1; def f() { var g = def() { x }; var r = g(); var x = 2; r } f()", 1},
+		{"var f = def(x) { var x = x + 1; x }; f(1)", 2},
+		{"var f = def([a, b], {c}) { a + b + c }; f([1, 2], {\"c\": 3})", 6},
+		{"def f(x) { match (x) { [h, ...t] => h + cat(t) } } f([5, 6, 7])", 7},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestConstStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"const a = 5; a;", 5},
+		{"const a = 5; var f = def() { var a = 10; a }; f() + a;", 15},
+		{"const a = 5; var f = def(a) { a }; f(7);", 7},
+		{"const a = 5; def f() { const a = 1; a } f() + a;", 6},
+		{"var a = 1; const a = 2; a;", 2},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestConstReassignmentAcrossPrograms(t *testing.T) {
+	env := object.NewEnvironment()
+
+	for _, input := range []string{"const limit = 3;", "var limit = 4;"} {
+		l := lexer.New(input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("Parser errors for %q: %v", input, p.Errors())
+		}
+		evaluated := Eval(program, env)
+		if input == "var limit = 4;" {
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+			}
+			if errObj.Message != "Cannot reassign constant: limit" {
+				t.Errorf("Wrong error message. Got %q", errObj.Message)
+			}
+		}
+	}
+
+	val, _ := env.Get("limit")
+	testIntegerObject(t, val, 3)
+}
+
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
@@ -540,3 +1426,227 @@ func TestLetStatements(t *testing.T) {
 		testIntegerObject(t, testEval(tt.input), tt.expected)
 	}
 }
+
+func TestDeclarationsHaveNoValue(t *testing.T) {
+	tests := []struct {
+		input string
+	}{
+		{"1; var x = 2"},
+		{"1; def g() { 2 }"},
+		{"1; struct P { x }"},
+		{"def outer() { inner(); def inner() { 5 } }; outer()"},
+		{"def f() { 1; var x = 2 }; f()"},
+		{"if (true) { 1; def g() { 2 } }"},
+	}
+	for _, tt := range tests {
+		if evaluated := testEval(tt.input); evaluated != nil && evaluated != NULL {
+			t.Errorf("%s: expected no value. got=%s", tt.input, evaluated.Inspect())
+		}
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	parse := func(input string) *ast.Program {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		resolver.New(BuiltinNames()...).Resolve(program)
+		return program
+	}
+
+	forever := parse("def f(n) { f(n + 1) }; f(0)")
+
+	evaluated := EvalContext(context.Background(), forever, object.NewEnvironment(), 1000)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "Step limit of 1000 exceeded" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+	if errObj.Cause != object.ErrStepLimit {
+		t.Errorf("wrong cause. got=%v", errObj.Cause)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	evaluated = EvalContext(ctx, forever, object.NewEnvironment(), 0)
+	errObj, ok = evaluated.(*object.Error)
+	if !ok || errObj.Cause != context.Canceled {
+		t.Fatalf("expected a cancellation error. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "Execution cancelled: context canceled" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	fib := parse("def fib(n) { if (n < 2) { n } el { fib(n - 1) + fib(n - 2) } }; fib(40)")
+	evaluated = EvalContext(ctx, fib, object.NewEnvironment(), 0)
+	errObj, ok = evaluated.(*object.Error)
+	if !ok || errObj.Cause != context.DeadlineExceeded {
+		t.Fatalf("expected a deadline error. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	// The limits only apply to the evaluation they were given for, but
+	// its counts stay readable after it.
+	env := object.NewEnvironment()
+	EvalContext(context.Background(), parse("def g(n) { n }; g(1); g(2)"), env, 1)
+	if steps := env.Runtime().Steps(); steps != 2 {
+		t.Errorf("wrong step count. got=%d", steps)
+	}
+	testIntegerObject(t, Eval(parse("g(3); g(4)"), env), 4)
+
+	evaluated = EvalContext(context.Background(), forever, object.NewEnvironment(), 1<<40)
+	errObj, ok = evaluated.(*object.Error)
+	if !ok || errObj.Cause != object.ErrCallDepth {
+		t.Fatalf("expected a call depth error. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "Maximum call depth of 10000 exceeded" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	env = object.NewEnvironment()
+	runtime := &object.Runtime{}
+	runtime.LimitDepth(50)
+	env.SetRuntime(runtime)
+	program := parse(`class A { def f(n) { if (n == 0) { 0 } el { self.f(n - 1) } } }; var a = A(); a.f(49)`)
+	testIntegerObject(t, EvalContext(context.Background(), program, env, 0), 0)
+	evaluated = EvalContext(context.Background(), parse("a.f(50)"), env, 0)
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Cause != object.ErrCallDepth {
+		t.Errorf("expected a call depth error from methods. got=%T(%+v)", evaluated, evaluated)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxBytes int64
+		expected interface{}
+	}{
+		{`"a" + "b"`, 1000, "ab"},
+		{"def grow(s, n) { if (n == 0) { s } el { grow(s + s, n - 1) } }; grow(\"ab\", 30)", 1 << 20, "Memory limit of 1048576 bytes exceeded"},
+		{"def grow(a, n) { if (n == 0) { a } el { grow(add(a, n), n - 1) } }; grow([], 10000)", 1 << 20, "Memory limit of 1048576 bytes exceeded"},
+		{"[...1..1000000000]", 1 << 20, "Memory limit of 1048576 bytes exceeded"},
+		{"elems(1..1000000000)", 1 << 20, "Memory limit of 1048576 bytes exceeded"},
+		{"[...1..=100].len()", 1 << 20, 100},
+		{"[...1..1000000]; 1", 0, 1},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		resolver.New(BuiltinNames()...).Resolve(program)
+
+		env := object.NewEnvironment()
+		runtime := &object.Runtime{}
+		runtime.LimitMemory(tt.maxBytes)
+		env.SetRuntime(runtime)
+
+		evaluated := Eval(program, env)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if errObj.Message != expected || errObj.Cause != object.ErrMemoryLimit {
+					t.Errorf("wrong error for %q. got=%q (cause %v)", tt.input, errObj.Message, errObj.Cause)
+				}
+				continue
+			}
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("wrong result for %q. got=%s", tt.input, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	safe := Builtins()
+	for _, name := range []string{"read", "write", "rand"} {
+		if _, ok := safe[name]; ok {
+			t.Errorf("%s should need a capability", name)
+		}
+	}
+	if _, ok := safe["cat"]; !ok {
+		t.Errorf("cat should need no capability")
+	}
+	if _, ok := Builtins(CapabilityIO)["write"]; !ok {
+		t.Errorf("write should be granted with %s", CapabilityIO)
+	}
+	if _, ok := Builtins(CapabilityIO)["rand"]; ok {
+		t.Errorf("rand should not be granted with %s", CapabilityIO)
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`cat("abc")`, 3},
+		{`"abc".len()`, 3},
+		{"rand(1, 2)", "Identifier not found: rand"},
+		{`write("hi")`, "Identifier not found: write"},
+		{"var rand = def(a, b) { a }; rand(1, 2)", 1},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		env := object.NewEnvironment()
+		runtime := &object.Runtime{}
+		runtime.SetBuiltins(safe)
+		env.SetRuntime(runtime)
+
+		evaluated := Eval(program, env)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok || errObj.Message != expected {
+				t.Errorf("wrong result for %q. expected=%q, got=%s", tt.input, expected, evaluated.Inspect())
+			}
+		}
+	}
+
+	// A method is only there if the builtin behind it is.
+	withoutCat := Builtins()
+	delete(withoutCat, "cat")
+	env := object.NewEnvironment()
+	runtime := &object.Runtime{}
+	runtime.SetBuiltins(withoutCat)
+	env.SetRuntime(runtime)
+	evaluated := Eval(parser.New(lexer.New(`"abc".len()`)).ParseProgram(), env)
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Message != "Undefined method len on STRING" {
+		t.Errorf("expected len to be undefined without cat. got=%s", evaluated.Inspect())
+	}
+}
+
+func TestInjectedIO(t *testing.T) {
+	input := `
+var a = read("a: ");
+var b = read("b: ");
+write(a + 1, " ", b);
+var c = read("c: ");
+c`
+
+	var out bytes.Buffer
+	env := object.NewEnvironment()
+	runtime := &object.Runtime{}
+	runtime.SetIO(strings.NewReader("41\nhello\nlast"), &out, nil)
+	env.SetRuntime(runtime)
+
+	evaluated := Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+
+	str, ok := evaluated.(*object.String)
+	if !ok || str.Value != "last" {
+		t.Errorf("read should return the unterminated last line. got=%s", evaluated.Inspect())
+	}
+	if out.String() != "a: b: 42 hello\nc: " {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	evaluated = Eval(parser.New(lexer.New(`read("")`)).ParseProgram(), env)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != "Failed to read input: EOF" {
+		t.Errorf("expected an EOF error. got=%s", evaluated.Inspect())
+	}
+}
@@ -0,0 +1,61 @@
+package evaluator
+
+import (
+	"squ1d/object"
+)
+
+// methods maps a method name on a built-in type to the builtin that
+// implements it. The receiver is passed as the builtin's first argument,
+// so s.len() is cat(s) and arr.push(x) is add(arr, x).
+var methods = map[object.ObjectType]map[string]string{
+	object.STRING_OBJ: {
+		"len":   "cat",
+		"int":   "tpint",
+		"field": "sepr",
+	},
+	object.ARRAY_OBJ: {
+		"len":   "cat",
+		"first": "first",
+		"last":  "last",
+		"push":  "add",
+		"set":   "set",
+	},
+	object.HASH_OBJ: {
+		"len":    "cat",
+		"keys":   "keys",
+		"values": "values",
+	},
+	object.SET_OBJ: {
+		"len":   "cat",
+		"union": "union",
+		"inter": "inter",
+		"diff":  "diff",
+		"elems": "elems",
+	},
+	object.RANGE_OBJ: {
+		"len":   "cat",
+		"first": "first",
+		"last":  "last",
+		"elems": "elems",
+	},
+}
+
+// lookupMethod finds the builtin implementing name on t among the builtins
+// of env's runtime, so a builtin left out of them is no method either.
+func lookupMethod(env *object.Environment, t object.ObjectType, name string) (*object.Builtin, bool) {
+	builtinName, ok := methods[t][name]
+	if !ok {
+		return nil, false
+	}
+	return lookupBuiltin(env, builtinName)
+}
+
+func applyBuiltinMethod(env *object.Environment, receiver object.Object, name string, args []object.Object) object.Object {
+	method, ok := lookupMethod(env, receiver.Type(), name)
+	if !ok {
+		return newError("Undefined method %s on %s", name, receiver.Type())
+	}
+
+	args = append([]object.Object{receiver}, args...)
+	return trackResult(env, method.Fn(env, args...), args)
+}
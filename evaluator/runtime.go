@@ -0,0 +1,130 @@
+package evaluator
+
+import (
+	"context"
+	"math"
+	"squ1d/ast"
+	"squ1d/object"
+)
+
+// Rough sizes, in bytes, of what the evaluator allocates, used to account
+// for a program's memory.
+const (
+	objectSize = 16 // an interface value or a boxed integer
+	entrySize  = 64 // a hash or set entry, with its share of the map
+)
+
+// EvalContext evaluates node like Eval, but stops with an error whose Cause
+// is ctx.Err() once ctx is done, or object.ErrStepLimit once the program has
+// made more than maxSteps calls. A maxSteps of 0 means no limit. Any other
+// settings of env's runtime, such as its memory limit, are kept; if env has
+// no runtime it is given one. The runtime's counts are left as the
+// evaluation made them.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, maxSteps int64) object.Object {
+	runtime := env.Runtime()
+	if runtime == nil {
+		runtime = &object.Runtime{}
+		env.SetRuntime(runtime)
+	}
+	runtime.Limit(ctx, maxSteps)
+	defer runtime.Release()
+
+	return Eval(node, env)
+}
+
+// step charges a call to the runtime env belongs to, if it has one.
+func step(env *object.Environment) *object.Error {
+	if env == nil {
+		return nil
+	}
+	if runtime := env.Runtime(); runtime != nil {
+		return runtime.Step()
+	}
+	return nil
+}
+
+// enter records a call in the runtime env belongs to, if it has one, and
+// returns the function that records it returning.
+func enter(env *object.Environment) (func(), *object.Error) {
+	if env == nil {
+		return func() {}, nil
+	}
+	runtime := env.Runtime()
+	if runtime == nil {
+		return func() {}, nil
+	}
+	if err := runtime.Enter(); err != nil {
+		return nil, err
+	}
+	return runtime.Leave, nil
+}
+
+// allocate charges size bytes to the runtime env belongs to, if it has one.
+func allocate(env *object.Environment, size int64) *object.Error {
+	if env == nil || size == 0 {
+		return nil
+	}
+	if runtime := env.Runtime(); runtime != nil {
+		return runtime.Allocate(size)
+	}
+	return nil
+}
+
+// track charges the memory of obj, which has just been created, and
+// returns it, or the error the program must stop with.
+func track(env *object.Environment, obj object.Object) object.Object {
+	if err := allocate(env, sizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
+// trackResult tracks what a builtin returned unless it was one of its
+// arguments, which have been accounted for already.
+func trackResult(env *object.Environment, result object.Object, args []object.Object) object.Object {
+	for _, arg := range args {
+		if result == arg {
+			return result
+		}
+	}
+	return track(env, result)
+}
+
+// sizeOf approximates the memory taken by obj itself, leaving out the
+// objects it refers to.
+func sizeOf(obj object.Object) int64 {
+	switch obj := obj.(type) {
+	case *object.String:
+		return objectSize + int64(len(obj.Value))
+	case *object.Array:
+		return objectSize + objectSize*int64(len(obj.Elements))
+	case *object.Hash:
+		return objectSize + entrySize*int64(len(obj.Pairs))
+	case *object.Set:
+		return objectSize + entrySize*int64(len(obj.Elements))
+	default:
+		return 0
+	}
+}
+
+// rangeValues lists the integers of rng, or returns an error if they exceed
+// the memory limit or, with or without one, number more than
+// object.MaxRangeValues.
+func rangeValues(env *object.Environment, rng *object.Range) ([]object.Object, object.Object) {
+	if err := allocate(env, sizeOfRange(rng)); err != nil {
+		return nil, err
+	}
+	if rng.Len() > object.MaxRangeValues {
+		return nil, newError("Range too long to list: %s", rng.Inspect())
+	}
+	return rng.Values(), nil
+}
+
+// sizeOfRange approximates the memory taken by the integers of rng once
+// they are listed out.
+func sizeOfRange(rng *object.Range) int64 {
+	if rng.Len() > math.MaxInt64/objectSize {
+		return math.MaxInt64
+	}
+	return objectSize * rng.Len()
+}
@@ -26,6 +26,13 @@ if (5 < 10) {
 "foo bar"
 [1, 2];
 {"foo": "bar"}
+1 in {1, 2};
+p.x
+a |> f
+(x) => x
+[...r]
+c ? a : b
+1..n 0..=9
 `
 
 	tests := []struct {
@@ -118,6 +125,40 @@ if (5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.INT, "1"},
+		{token.IN, "in"},
+		{token.LBRACE, "{"},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACE, "}"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "p"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.IDENT, "a"},
+		{token.PIPE, "|>"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "x"},
+		{token.LBRACKET, "["},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "r"},
+		{token.RBRACKET, "]"},
+		{token.IDENT, "c"},
+		{token.QUESTION, "?"},
+		{token.IDENT, "a"},
+		{token.COLON, ":"},
+		{token.IDENT, "b"},
+		{token.INT, "1"},
+		{token.RANGE, ".."},
+		{token.IDENT, "n"},
+		{token.INT, "0"},
+		{token.RANGE_EQ, "..="},
+		{token.INT, "9"},
 		{token.EOF, ""},
 	}
 
@@ -1,9 +1,7 @@
 package object
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	return &Environment{outer: outer}
 }
 
 func NewEnvironment() *Environment {
@@ -11,9 +9,36 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s, outer: nil}
 }
 
+// Environment holds bindings by name in store and, for locals the resolver
+// has assigned a position to, by index in slots.
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	slots  []Object
+	consts map[string]bool
+	outer  *Environment
+
+	// runtime is only set on the outermost environment.
+	runtime *Runtime
+}
+
+// Runtime returns the runtime attached to the outermost environment, or nil
+// if there is none.
+func (e *Environment) Runtime() *Runtime {
+	if e == nil {
+		return nil
+	}
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e.runtime
+}
+
+// SetRuntime attaches r to the outermost environment.
+func (e *Environment) SetRuntime(r *Runtime) {
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.runtime = r
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -24,7 +49,52 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Set binds name in this scope. Rebinding a constant declared in the same
+// scope is refused with an *Error; shadowing it from an enclosed scope is fine.
 func (e *Environment) Set(name string, val Object) Object {
+	if e.consts[name] {
+		return &Error{Message: "Cannot reassign constant: " + name}
+	}
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
 	return val
 }
+
+func (e *Environment) SetConst(name string, val Object) Object {
+	if result := e.Set(name, val); result != val {
+		return result
+	}
+	if e.consts == nil {
+		e.consts = make(map[string]bool)
+	}
+	e.consts[name] = true
+	return val
+}
+
+// GetAt returns the value in slot of the environment depth levels out. It
+// reports false when that slot has not been assigned yet.
+func (e *Environment) GetAt(depth, slot int) (Object, bool) {
+	for ; depth > 0 && e != nil; depth-- {
+		e = e.outer
+	}
+	if e == nil || slot >= len(e.slots) || e.slots[slot] == nil {
+		return nil, false
+	}
+	return e.slots[slot], true
+}
+
+// SetAt stores val in slot of this environment. Constants in slots are
+// checked by the parser, so no check is made here.
+func (e *Environment) SetAt(slot int, val Object) Object {
+	if slot >= cap(e.slots) {
+		slots := make([]Object, slot+1, 2*slot+2)
+		copy(slots, e.slots)
+		e.slots = slots
+	} else if slot >= len(e.slots) {
+		e.slots = e.slots[:slot+1]
+	}
+	e.slots[slot] = val
+	return val
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"sort"
 	"squ1d/ast"
+	"squ1d/code"
 	"strings"
 )
 
@@ -33,6 +36,15 @@ const (
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	SET_OBJ          = "SET"
+	RANGE_OBJ        = "RANGE"
+	STRUCT_TYPE_OBJ  = "STRUCT_TYPE"
+	STRUCT_OBJ       = "STRUCT"
+	CLASS_OBJ        = "CLASS"
+	INSTANCE_OBJ     = "INSTANCE"
+	SUPER_OBJ        = "SUPER"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 type Object interface {
@@ -44,6 +56,30 @@ type Integer struct {
 	Value int64
 }
 
+// Integers in this range are preallocated, since they make up most of the
+// values scripts compute with. Integers are never mutated, so one object
+// can stand for every occurrence of its value.
+const (
+	minCachedInteger = -256
+	maxCachedInteger = 1024
+)
+
+var cachedIntegers = func() []*Integer {
+	integers := make([]*Integer, maxCachedInteger-minCachedInteger+1)
+	for i := range integers {
+		integers[i] = &Integer{Value: int64(i + minCachedInteger)}
+	}
+	return integers
+}()
+
+// NewInteger returns an Integer for value, shared when value is small.
+func NewInteger(value int64) *Integer {
+	if value >= minCachedInteger && value <= maxCachedInteger {
+		return cachedIntegers[value-minCachedInteger]
+	}
+	return &Integer{Value: value}
+}
+
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
@@ -66,11 +102,12 @@ type Array struct {
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
+func (ao *Array) Inspect() string  { return inspect(ao, map[Object]bool{}) }
+func (ao *Array) inspect(seen map[Object]bool) string {
 	var out bytes.Buffer
 	elements := []string{}
 	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, inspect(e, seen))
 	}
 	out.WriteString("[")
 	out.WriteString(strings.Join(elements, ", "))
@@ -78,6 +115,27 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
+// container is implemented by the objects that hold other objects, which
+// field assignment can make contain themselves.
+type container interface {
+	inspect(seen map[Object]bool) string
+}
+
+// inspect renders obj inside the containers in seen, printing a container
+// that is already being printed as ... rather than recursing forever.
+func inspect(obj Object, seen map[Object]bool) string {
+	c, ok := obj.(container)
+	if !ok {
+		return obj.Inspect()
+	}
+	if seen[obj] {
+		return "..."
+	}
+	seen[obj] = true
+	defer delete(seen, obj)
+	return c.inspect(seen)
+}
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType {
@@ -100,13 +158,30 @@ func (rv *ReturnValue) Inspect() string {
 
 type Error struct {
 	Message string
+	// Trace lists the named functions the error propagated out of,
+	// innermost first.
+	Trace []string
+	// Cause is set on errors raised by the runtime rather than the
+	// program, such as context.Canceled or ErrStepLimit.
+	Cause error
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	var out bytes.Buffer
+
+	out.WriteString("ERROR: " + e.Message)
+	for _, frame := range e.Trace {
+		out.WriteString("\n\tin " + frame)
+	}
+
+	return out.String()
+}
 
 type Function struct {
+	Name       string
 	Parameters []*ast.Identifier
+	Patterns   []ast.Expression
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -121,6 +196,9 @@ func (f *Function) Inspect() string {
 	}
 
 	out.WriteString("fn")
+	if f.Name != "" {
+		out.WriteString(" " + f.Name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
@@ -130,6 +208,40 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// CompiledFunction is a function body compiled to bytecode for the vm.
+type CompiledFunction struct {
+	Name          string
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+
+	// Prologue is the length of the instructions destructuring parameter
+	// patterns. The evaluator binds parameters before entering the body,
+	// so errors raised there are not traced to the function.
+	Prologue int
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is the vm's runtime function value: a compiled function together
+// with the free variables it captured. It reports itself as a FUNCTION so
+// scripts see the same type under either engine.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	if c.Fn.Name != "" {
+		return "fn " + c.Fn.Name + "(...) {...}"
+	}
+	return "fn(...) {...}"
+}
+
 type Builtin struct {
 	Fn BuiltinFunction
 }
@@ -179,12 +291,13 @@ type Hash struct {
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string  { return inspect(h, map[Object]bool{}) }
+func (h *Hash) inspect(seen map[Object]bool) string {
 	var out bytes.Buffer
 
 	pairs := []string{}
 	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), inspect(pair.Value, seen)))
 	}
 
 	out.WriteString("{")
@@ -193,3 +306,223 @@ func (h *Hash) Inspect() string {
 
 	return out.String()
 }
+
+type Set struct {
+	Elements map[HashKey]Object
+	Order    []HashKey
+}
+
+func NewSet() *Set {
+	return &Set{Elements: make(map[HashKey]Object)}
+}
+
+// Add inserts value under key, keeping the order elements were first added in.
+func (s *Set) Add(key HashKey, value Object) {
+	if _, ok := s.Elements[key]; !ok {
+		s.Order = append(s.Order, key)
+	}
+	s.Elements[key] = value
+}
+
+func (s *Set) Contains(key HashKey) bool {
+	_, ok := s.Elements[key]
+	return ok
+}
+
+func (s *Set) Values() []Object {
+	values := make([]Object, 0, len(s.Order))
+	for _, key := range s.Order {
+		values = append(values, s.Elements[key])
+	}
+	return values
+}
+
+func (s *Set) Type() ObjectType { return SET_OBJ }
+func (s *Set) Inspect() string  { return inspect(s, map[Object]bool{}) }
+func (s *Set) inspect(seen map[Object]bool) string {
+	if len(s.Order) == 0 {
+		return "set()"
+	}
+
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range s.Values() {
+		elements = append(elements, inspect(e, seen))
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// MaxRangeValues is the most integers a range can be listed out into, with
+// or without a memory limit; see Range.Values.
+const MaxRangeValues = 1 << 22
+
+// Range is the integers from Start up to End, including End only when
+// Inclusive is set. Elements are computed on demand rather than stored.
+type Range struct {
+	Start     int64
+	End       int64
+	Inclusive bool
+}
+
+// Len returns the number of integers in r, or math.MaxInt64 if there are
+// more than that; see TooLong.
+func (r *Range) Len() int64 {
+	if r.End < r.Start || r.End == r.Start && !r.Inclusive {
+		return 0
+	}
+	if r.TooLong() {
+		return math.MaxInt64
+	}
+	// End - Start can overflow an int64, but not a uint64.
+	n := uint64(r.End) - uint64(r.Start)
+	if r.Inclusive {
+		n++
+	}
+	return int64(n)
+}
+
+// TooLong reports whether r holds more integers than an int64 can count,
+// as -9223372036854775808..9223372036854775807 does.
+func (r *Range) TooLong() bool {
+	if r.End < r.Start {
+		return false
+	}
+	n := uint64(r.End) - uint64(r.Start)
+	return n > math.MaxInt64 || n == math.MaxInt64 && r.Inclusive
+}
+
+func (r *Range) Contains(n int64) bool {
+	if r.Inclusive {
+		return n >= r.Start && n <= r.End
+	}
+	return n >= r.Start && n < r.End
+}
+
+// Values materializes the range into a slice of integers.
+// Values lists the integers of r. Callers check Len against MaxRangeValues
+// first, as a range can hold far more integers than fit in memory.
+func (r *Range) Values() []Object {
+	values := make([]Object, r.Len())
+	for i := range values {
+		values[i] = NewInteger(r.Start + int64(i))
+	}
+	return values
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Inclusive {
+		return fmt.Sprintf("%d..=%d", r.Start, r.End)
+	}
+	return fmt.Sprintf("%d..%d", r.Start, r.End)
+}
+
+type StructType struct {
+	Name   string
+	Fields []string
+}
+
+func (st *StructType) Type() ObjectType { return STRUCT_TYPE_OBJ }
+func (st *StructType) Inspect() string {
+	return "struct " + st.Name + " { " + strings.Join(st.Fields, ", ") + " }"
+}
+
+func (st *StructType) HasField(name string) bool {
+	for _, f := range st.Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+type Struct struct {
+	Definition *StructType
+	Fields     map[string]Object
+}
+
+func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
+func (s *Struct) Inspect() string  { return inspect(s, map[Object]bool{}) }
+func (s *Struct) inspect(seen map[Object]bool) string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for _, name := range s.Definition.Fields {
+		fields = append(fields, fmt.Sprintf("%s: %s", name, inspect(s.Fields[name], seen)))
+	}
+
+	out.WriteString(s.Definition.Name)
+	out.WriteString("{")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+type Class struct {
+	Name       string
+	Superclass *Class
+	// Methods holds a *Function for each method of a class the evaluator
+	// declared, or a *Closure for one the vm declared.
+	Methods map[string]Object
+}
+
+func (c *Class) Type() ObjectType { return CLASS_OBJ }
+func (c *Class) Inspect() string  { return "class " + c.Name }
+
+// FindMethod looks name up on c and then along its superclass chain. It
+// also returns the class that defined the method, which is what `super`
+// inside that method refers past.
+func (c *Class) FindMethod(name string) (Object, *Class) {
+	for class := c; class != nil; class = class.Superclass {
+		if method, ok := class.Methods[name]; ok {
+			return method, class
+		}
+	}
+	return nil, nil
+}
+
+type Instance struct {
+	Class  *Class
+	Fields map[string]Object
+}
+
+func (i *Instance) Type() ObjectType { return INSTANCE_OBJ }
+func (i *Instance) Inspect() string  { return inspect(i, map[Object]bool{}) }
+func (i *Instance) inspect(seen map[Object]bool) string {
+	var out bytes.Buffer
+
+	names := make([]string, 0, len(i.Fields))
+	for name := range i.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := []string{}
+	for _, name := range names {
+		fields = append(fields, fmt.Sprintf("%s: %s", name, inspect(i.Fields[name], seen)))
+	}
+
+	out.WriteString(i.Class.Name)
+	out.WriteString("{")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Super is bound to `super` inside a method. Method calls on it start the
+// lookup at Class while keeping Self as the receiver.
+type Super struct {
+	Self  *Instance
+	Class *Class
+}
+
+func (s *Super) Type() ObjectType { return SUPER_OBJ }
+func (s *Super) Inspect() string  { return "super " + s.Class.Name }
@@ -21,4 +21,50 @@ func TestStringHashKey(t *testing.T) {
 	if hello1.HashKey() == diff1.HashKey() {
 		t.Errorf("Strings with different content have same hash keys")
 	}
-}
\ No newline at end of file
+}
+func TestEnvironmentConstants(t *testing.T) {
+	env := NewEnvironment()
+	one := &Integer{Value: 1}
+	two := &Integer{Value: 2}
+
+	if result := env.SetConst("x", one); result != one {
+		t.Fatalf("SetConst returned %+v", result)
+	}
+
+	if err, ok := env.Set("x", two).(*Error); !ok || err.Message != "Cannot reassign constant: x" {
+		t.Errorf("Set on a constant did not fail. Got %+v", err)
+	}
+
+	if _, ok := env.SetConst("x", two).(*Error); !ok {
+		t.Errorf("SetConst on a constant did not fail")
+	}
+
+	inner := NewEnclosedEnvironment(env)
+	if result := inner.Set("x", two); result != two {
+		t.Errorf("Shadowing a constant in an enclosed scope failed. Got %+v", result)
+	}
+
+	if val, _ := env.Get("x"); val != one {
+		t.Errorf("Constant was changed. Got %+v", val)
+	}
+}
+
+func TestNewInteger(t *testing.T) {
+	for _, value := range []int64{-256, -1, 0, 1, 1024} {
+		if NewInteger(value) != NewInteger(value) {
+			t.Errorf("NewInteger(%d) is not cached", value)
+		}
+		if NewInteger(value).Value != value {
+			t.Errorf("NewInteger(%d) has value %d", value, NewInteger(value).Value)
+		}
+	}
+
+	for _, value := range []int64{-257, 1025, 1 << 40} {
+		if NewInteger(value) == NewInteger(value) {
+			t.Errorf("NewInteger(%d) should not be cached", value)
+		}
+		if NewInteger(value).Value != value {
+			t.Errorf("NewInteger(%d) has value %d", value, NewInteger(value).Value)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package object
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrStepLimit is the Cause of the error a program stops with once it has
+// used up its step budget.
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// ErrMemoryLimit is the Cause of the error a program stops with once it has
+// allocated more than its memory limit.
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// ErrCallDepth is the Cause of the error a program stops with once its
+// calls nest deeper than its call depth limit.
+var ErrCallDepth = errors.New("call depth exceeded")
+
+// DefaultMaxDepth is the call depth limit of a Runtime that has not been
+// given one. It stops runaway recursion well before it exhausts the Go
+// stack.
+const DefaultMaxDepth = 10000
+
+// Runtime holds the limits of one evaluation, the builtins it may use and
+// the streams they read and write. It is attached to the
+// outermost environment, where every environment enclosed in it finds it.
+type Runtime struct {
+	ctx      context.Context
+	maxSteps int64
+	steps    int64
+
+	maxDepth int
+	depth    int
+
+	maxMemory int64
+	allocated int64
+
+	builtins map[string]*Builtin
+
+	// flatBlocks turns block scoping off, so the zero Runtime scopes
+	// blocks like an environment without one.
+	flatBlocks bool
+
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// Limit resets the step and memory counts and bounds the following
+// evaluation. A nil ctx never stops it and a maxSteps of 0 allows any
+// number of steps.
+func (r *Runtime) Limit(ctx context.Context, maxSteps int64) {
+	r.ctx = ctx
+	r.maxSteps = maxSteps
+	r.steps = 0
+	r.allocated = 0
+	r.depth = 0
+}
+
+// Release lifts the bounds set by Limit once an evaluation is over. The
+// counts are kept, so Steps and Allocated still report on it.
+func (r *Runtime) Release() {
+	r.ctx = nil
+	r.maxSteps = 0
+}
+
+// LimitDepth bounds how deeply calls may nest. A maxDepth of 0 restores
+// DefaultMaxDepth.
+func (r *Runtime) LimitDepth(maxDepth int) {
+	r.maxDepth = maxDepth
+}
+
+// LimitMemory bounds the approximate number of bytes the program may
+// allocate for strings, arrays, hashes and sets. A maxBytes of 0 means no
+// limit. Memory is counted as it is allocated and never given back, so
+// this bounds the work a program does rather than what it holds at once.
+func (r *Runtime) LimitMemory(maxBytes int64) {
+	r.maxMemory = maxBytes
+}
+
+// Steps returns the number of steps taken since the last Limit.
+func (r *Runtime) Steps() int64 {
+	return r.steps
+}
+
+// SetBuiltins replaces the builtins programs run under r can call. A nil
+// map restores the evaluator's default builtins.
+func (r *Runtime) SetBuiltins(builtins map[string]*Builtin) {
+	r.builtins = builtins
+}
+
+// Builtins returns the builtins given to SetBuiltins, or nil.
+func (r *Runtime) Builtins() map[string]*Builtin {
+	return r.builtins
+}
+
+// SetBlockScoping decides whether every if/el body gets its own
+// environment, so bindings made inside a block are not visible after it.
+// Turning it off evaluates bodies directly in the enclosing environment,
+// saving an allocation per block at the cost of leaking block-local
+// variables. It is on by default, and must agree with the resolver's
+// BlockScoping, as it decides which environment a block's bindings live in.
+func (r *Runtime) SetBlockScoping(enabled bool) {
+	r.flatBlocks = !enabled
+}
+
+// BlockScoping reports whether if/el bodies get their own environment.
+func (r *Runtime) BlockScoping() bool {
+	return !r.flatBlocks
+}
+
+// SetIO directs the builtins of programs run under r to read from in and
+// write to out and errOut. A nil stream leaves the process's own in place.
+// Input is buffered, so a caller that reads from in as well, such as the
+// REPL, should pass a *bufio.Reader and read through it.
+func (r *Runtime) SetIO(in io.Reader, out, errOut io.Writer) {
+	r.stdin = nil
+	if in != nil {
+		if reader, ok := in.(*bufio.Reader); ok {
+			r.stdin = reader
+		} else {
+			r.stdin = bufio.NewReader(in)
+		}
+	}
+	r.stdout = out
+	r.stderr = errOut
+}
+
+// Stdin, Stdout and Stderr return the streams given to SetIO, or nil.
+
+func (r *Runtime) Stdin() *bufio.Reader {
+	return r.stdin
+}
+
+func (r *Runtime) Stdout() io.Writer {
+	return r.stdout
+}
+
+func (r *Runtime) Stderr() io.Writer {
+	return r.stderr
+}
+
+// Allocated returns the approximate number of bytes allocated since the
+// last Limit.
+func (r *Runtime) Allocated() int64 {
+	return r.allocated
+}
+
+// Allocate charges bytes of memory and returns the error the program must
+// stop with if that exceeds the memory limit.
+func (r *Runtime) Allocate(bytes int64) *Error {
+	r.allocated += bytes
+	if r.maxMemory > 0 && r.allocated > r.maxMemory {
+		return &Error{Message: fmt.Sprintf("Memory limit of %d bytes exceeded", r.maxMemory), Cause: ErrMemoryLimit}
+	}
+	return nil
+}
+
+// Enter records a call being made and returns the error the program must
+// stop with if calls now nest deeper than the limit. Every successful
+// Enter must be matched by a Leave once the call returns.
+func (r *Runtime) Enter() *Error {
+	maxDepth := r.maxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if r.depth >= maxDepth {
+		return &Error{Message: fmt.Sprintf("Maximum call depth of %d exceeded", maxDepth), Cause: ErrCallDepth}
+	}
+	r.depth++
+	return nil
+}
+
+// Leave records a call entered with Enter returning.
+func (r *Runtime) Leave() {
+	r.depth--
+}
+
+// Step charges one step, such as a function call, and returns the error
+// the program must stop with if that exceeds the budget or the context is
+// done.
+func (r *Runtime) Step() *Error {
+	r.steps++
+	if r.maxSteps > 0 && r.steps > r.maxSteps {
+		return &Error{Message: fmt.Sprintf("Step limit of %d exceeded", r.maxSteps), Cause: ErrStepLimit}
+	}
+	if r.ctx != nil {
+		select {
+		case <-r.ctx.Done():
+			return &Error{Message: "Execution cancelled: " + r.ctx.Err().Error(), Cause: r.ctx.Err()}
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package optimizer
+
+import (
+	"squ1d/ast"
+	"squ1d/evaluator"
+	"squ1d/object"
+	"squ1d/token"
+	"strconv"
+)
+
+// scope tracks the constants visible at a point in the program. A nil entry
+// means the name is bound to something else there, shadowing any constant
+// further out.
+type scope struct {
+	parent *scope
+	consts map[string]ast.Expression
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, consts: make(map[string]ast.Expression)}
+}
+
+func (s *scope) shadow(name string) {
+	s.consts[name] = nil
+}
+
+func (s *scope) lookup(name string) (ast.Expression, bool) {
+	for ; s != nil; s = s.parent {
+		if value, ok := s.consts[name]; ok {
+			return value, value != nil
+		}
+	}
+	return nil, false
+}
+
+// Optimize rewrites program in place and returns it. It folds operators
+// whose operands are literals, inlines constants bound to literals, and
+// drops the branches of if expressions whose condition is a literal.
+// Expressions that would fail at runtime are left alone, so errors are
+// still raised where the program reaches them.
+func Optimize(program *ast.Program) *ast.Program {
+	program.Statements = optimizeStatements(program.Statements, newScope(nil))
+	return program
+}
+
+func optimizeStatements(statements []ast.Statement, s *scope) []ast.Statement {
+	// A name the block declares anywhere shadows constants further out for
+	// the whole block, as a closure made before the declaration runs sees
+	// the block's binding once it is made. Hoisted functions may be called
+	// before any other statement of the block runs, so their bodies only
+	// see constants declared around it.
+	for _, statement := range statements {
+		shadowDeclaration(statement, s)
+	}
+	for _, statement := range statements {
+		if fs, ok := statement.(*ast.FunctionStatement); ok {
+			optimizeFunction(fs.Function, s)
+		}
+	}
+
+	optimized := make([]ast.Statement, 0, len(statements))
+	for i, statement := range statements {
+		if _, ok := statement.(*ast.FunctionStatement); !ok {
+			statement = optimizeStatement(statement, s)
+		}
+
+		es, ok := statement.(*ast.ExpressionStatement)
+		if !ok {
+			optimized = append(optimized, statement)
+			continue
+		}
+
+		ie, ok := es.Expression.(*ast.IfExpression)
+		if !ok {
+			optimized = append(optimized, statement)
+			continue
+		}
+
+		// A pruned if either always runs its block or always yields null.
+		// The block can join the enclosing one as long as it declares
+		// nothing, and a null statement can go unless it is the value of
+		// the block.
+		switch {
+		case isBoolean(ie.Condition, true) && len(ie.Consequence.Statements) > 0 && !declares(ie.Consequence):
+			optimized = append(optimized, ie.Consequence.Statements...)
+		case isBoolean(ie.Condition, false) && i < len(statements)-1:
+		default:
+			optimized = append(optimized, statement)
+		}
+	}
+
+	return optimized
+}
+
+func optimizeStatement(statement ast.Statement, s *scope) ast.Statement {
+	switch statement := statement.(type) {
+	case *ast.ExpressionStatement:
+		statement.Expression = optimizeExpression(statement.Expression, s)
+	case *ast.ReturnStatement:
+		statement.ReturnValue = optimizeExpression(statement.ReturnValue, s)
+	case *ast.LetStatement:
+		statement.Value = optimizeExpression(statement.Value, s)
+		if statement.Pattern != nil {
+			shadowPattern(statement.Pattern, s)
+			break
+		}
+		if _, ok := constant(statement.Value); ok && statement.Token.Type == token.CONST {
+			s.consts[statement.Name.Value] = statement.Value
+		} else {
+			s.shadow(statement.Name.Value)
+		}
+	case *ast.StructStatement:
+		s.shadow(statement.Name.Value)
+	case *ast.ClassStatement:
+		for _, method := range statement.Methods {
+			optimizeFunction(method, s)
+		}
+		s.shadow(statement.Name.Value)
+	}
+	return statement
+}
+
+func optimizeBlock(block *ast.BlockStatement, s *scope) {
+	if block != nil {
+		block.Statements = optimizeStatements(block.Statements, newScope(s))
+	}
+}
+
+func optimizeFunction(fl *ast.FunctionLiteral, parent *scope) {
+	s := newScope(parent)
+	for i, param := range fl.Parameters {
+		if fl.Patterns != nil && fl.Patterns[i] != nil {
+			shadowPattern(fl.Patterns[i], s)
+			continue
+		}
+		s.shadow(param.Value)
+	}
+	fl.Body.Statements = optimizeStatements(fl.Body.Statements, s)
+}
+
+func optimizeExpression(expression ast.Expression, s *scope) ast.Expression {
+	switch node := expression.(type) {
+	case *ast.Identifier:
+		if value, ok := s.lookup(node.Value); ok {
+			return value
+		}
+	case *ast.PrefixExpression:
+		node.Right = optimizeExpression(node.Right, s)
+		if right, ok := constant(node.Right); ok {
+			if folded, ok := literal(evaluator.ApplyPrefix(node.Operator, right)); ok {
+				return folded
+			}
+		}
+	case *ast.InfixExpression:
+		node.Left = optimizeExpression(node.Left, s)
+		node.Right = optimizeExpression(node.Right, s)
+		left, leftOk := constant(node.Left)
+		right, rightOk := constant(node.Right)
+		if leftOk && rightOk {
+			if folded, ok := literal(evaluator.ApplyInfix(node.Operator, left, right)); ok {
+				return folded
+			}
+		}
+	case *ast.IfExpression:
+		return optimizeIfExpression(node, s)
+	case *ast.TernaryExpression:
+		node.Condition = optimizeExpression(node.Condition, s)
+		node.Consequence = optimizeExpression(node.Consequence, s)
+		node.Alternative = optimizeExpression(node.Alternative, s)
+		if condition, ok := constant(node.Condition); ok {
+			if evaluator.IsTruthy(condition) {
+				return node.Consequence
+			}
+			return node.Alternative
+		}
+	case *ast.FunctionLiteral:
+		optimizeFunction(node, s)
+	case *ast.CallExpression:
+		node.Function = optimizeExpression(node.Function, s)
+		optimizeExpressions(node.Arguments, s)
+	case *ast.MethodCallExpression:
+		node.Object = optimizeExpression(node.Object, s)
+		optimizeExpressions(node.Arguments, s)
+	case *ast.DotExpression:
+		node.Left = optimizeExpression(node.Left, s)
+	case *ast.AssignExpression:
+		// The target is left as written so assigning to a constant still
+		// fails at runtime.
+		node.Value = optimizeExpression(node.Value, s)
+	case *ast.IndexExpression:
+		node.Left = optimizeExpression(node.Left, s)
+		node.Index = optimizeExpression(node.Index, s)
+	case *ast.ArrayLiteral:
+		optimizeExpressions(node.Elements, s)
+	case *ast.SetLiteral:
+		optimizeExpressions(node.Elements, s)
+	case *ast.SpreadExpression:
+		node.Value = optimizeExpression(node.Value, s)
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(node.Pairs))
+		for i, key := range node.Entries {
+			if spread, ok := key.(*ast.SpreadExpression); ok {
+				spread.Value = optimizeExpression(spread.Value, s)
+				continue
+			}
+			node.Entries[i] = optimizeExpression(key, s)
+			pairs[node.Entries[i]] = optimizeExpression(node.Pairs[key], s)
+		}
+		node.Pairs = pairs
+	case *ast.MatchExpression:
+		node.Subject = optimizeExpression(node.Subject, s)
+		for _, arm := range node.Arms {
+			armScope := newScope(s)
+			arm.Pattern = optimizeMatchPattern(arm.Pattern, armScope)
+			if arm.Guard != nil {
+				arm.Guard = optimizeExpression(arm.Guard, armScope)
+			}
+			arm.Body.Statements = optimizeStatements(arm.Body.Statements, armScope)
+		}
+	}
+	return expression
+}
+
+func optimizeExpressions(expressions []ast.Expression, s *scope) {
+	for i, e := range expressions {
+		expressions[i] = optimizeExpression(e, s)
+	}
+}
+
+// optimizeIfExpression keeps only the branch a literal condition selects.
+// The branch stays wrapped in an if so it still gets its own environment.
+func optimizeIfExpression(ie *ast.IfExpression, s *scope) ast.Expression {
+	ie.Condition = optimizeExpression(ie.Condition, s)
+	optimizeBlock(ie.Consequence, s)
+	optimizeBlock(ie.Alternative, s)
+
+	condition, ok := constant(ie.Condition)
+	if !ok {
+		return ie
+	}
+
+	switch {
+	case evaluator.IsTruthy(condition):
+		ie.Condition = newBoolean(true)
+	case ie.Alternative != nil:
+		ie.Condition = newBoolean(true)
+		ie.Consequence = ie.Alternative
+	default:
+		ie.Condition = newBoolean(false)
+		ie.Consequence = &ast.BlockStatement{Token: ie.Consequence.Token}
+	}
+	ie.Alternative = nil
+
+	return ie
+}
+
+// optimizeMatchPattern follows the evaluator's matchPattern: bare names
+// bind, hash keys and type pattern names are taken literally, and anything
+// else is an expression.
+func optimizeMatchPattern(pattern ast.Expression, s *scope) ast.Expression {
+	switch node := pattern.(type) {
+	case *ast.Identifier:
+		s.shadow(node.Value)
+	case *ast.ArrayLiteral:
+		for i, el := range node.Elements {
+			if spread, ok := el.(*ast.SpreadExpression); ok {
+				optimizeMatchPattern(spread.Value, s)
+				continue
+			}
+			node.Elements[i] = optimizeMatchPattern(el, s)
+		}
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(node.Pairs))
+		for i, key := range node.Entries {
+			value := node.Pairs[key]
+			if _, ok := key.(*ast.Identifier); !ok {
+				key = optimizeExpression(key, s)
+			}
+			node.Entries[i] = key
+			pairs[key] = optimizeMatchPattern(value, s)
+		}
+		node.Pairs = pairs
+	case *ast.CallExpression:
+		if _, ok := node.Function.(*ast.Identifier); !ok {
+			return optimizeExpression(node, s)
+		}
+		for i, arg := range node.Arguments {
+			node.Arguments[i] = optimizeMatchPattern(arg, s)
+		}
+	default:
+		return optimizeExpression(pattern, s)
+	}
+	return pattern
+}
+
+// shadowDeclaration shadows the names statement declares, if any.
+func shadowDeclaration(statement ast.Statement, s *scope) {
+	switch statement := statement.(type) {
+	case *ast.LetStatement:
+		if statement.Pattern != nil {
+			shadowPattern(statement.Pattern, s)
+		} else {
+			s.shadow(statement.Name.Value)
+		}
+	case *ast.FunctionStatement:
+		s.shadow(statement.Name.Value)
+	case *ast.StructStatement:
+		s.shadow(statement.Name.Value)
+	case *ast.ClassStatement:
+		s.shadow(statement.Name.Value)
+	}
+}
+
+func shadowPattern(pattern ast.Expression, s *scope) {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		for _, name := range pattern.Elements {
+			s.shadow(name.Value)
+		}
+		if pattern.Rest != nil {
+			s.shadow(pattern.Rest.Value)
+		}
+	case *ast.HashPattern:
+		for _, key := range pattern.Keys {
+			s.shadow(key.Value)
+		}
+	}
+}
+
+// declares reports whether block binds any name of its own, which would
+// leak if its statements were moved into the enclosing block.
+func declares(block *ast.BlockStatement) bool {
+	for _, statement := range block.Statements {
+		switch statement.(type) {
+		case *ast.LetStatement, *ast.FunctionStatement, *ast.StructStatement, *ast.ClassStatement:
+			return true
+		}
+	}
+	return false
+}
+
+func isBoolean(expression ast.Expression, value bool) bool {
+	b, ok := expression.(*ast.Boolean)
+	return ok && b.Value == value
+}
+
+// constant returns the value of a literal the optimizer can fold.
+func constant(expression ast.Expression) (object.Object, bool) {
+	switch node := expression.(type) {
+	case *ast.IntegerLiteral:
+		return object.NewInteger(node.Value), true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.Boolean:
+		if node.Value {
+			return evaluator.TRUE, true
+		}
+		return evaluator.FALSE, true
+	}
+	return nil, false
+}
+
+// literal turns a folded value back into a node, if it has a literal form.
+func literal(obj object.Object) (ast.Expression, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return &ast.IntegerLiteral{
+			Token: token.Token{Type: token.INT, Literal: strconv.FormatInt(obj.Value, 10)},
+			Value: obj.Value,
+		}, true
+	case *object.String:
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: obj.Value}, Value: obj.Value}, true
+	case *object.Boolean:
+		return newBoolean(obj.Value), true
+	}
+	return nil, false
+}
+
+func newBoolean(value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true}
+	}
+	return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false"}, Value: false}
+}
@@ -0,0 +1,96 @@
+package optimizer
+
+import (
+	"squ1d/ast"
+	"squ1d/evaluator"
+	"squ1d/lexer"
+	"squ1d/object"
+	"squ1d/parser"
+	"testing"
+)
+
+// optimize parses input and optimizes it.
+func optimize(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	return Optimize(program)
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"60 * 60 * 24", "86400"},
+		{"-(2 + 3)", "-5"},
+		{"!true", "false"},
+		{"1 < 2 == true", "true"},
+		{`"foo" + "bar"`, "foobar"},
+		{"x + 2 * 3", "(x + 6)"},
+		{"1 / 0", "(1 / 0)"},
+		{"1..3", "(1 .. 3)"},
+		{"true ? x : y", "x"},
+		{"1 > 2 ? x : y", "y"},
+		{"const A = 2; A * 3", "const A = 2;6"},
+		{"var a = 2; a * 3", "var a = 2;(a * 3)"},
+		{"const A = 2; def(A) { A }", "const A = 2;def(A) A"},
+		{"const A = 2; def(x) { var A = 1; A }", "const A = 2;def(x) var A = 1;A"},
+		{"const A = 2; A.x = 3", "const A = 2;(A.x) = 3"},
+		{"const A = [1]; A", "const A = [1];A"},
+		{"f(); const A = 1; def f() { A }", "f()const A = 1;def f() A"},
+		{"const x = 1; def() { var f = def() { x }; var x = 2; x }", "const x = 1;def() var f = def() x;var x = 2;x"},
+		{"if (true) { x }; y", "xy"},
+		{"if (false) { x }; y", "y"},
+		{"if (false) { x }", "iffalse "},
+		{"if (false) { x } el { y }; z", "yz"},
+		{"if (1 < 2) { var a = 1; a }; z", "iftrue var a = 1;az"},
+		{"var r = if (false) { 1 } el { 2 }", "var r = iftrue 2;"},
+		{"match (x) { A => A, _ => 1 + 1 }", "matchx { A => A, _ => 2 }"},
+	}
+
+	for _, tt := range tests {
+		program := optimize(t, tt.input)
+		if program.String() != tt.expected {
+			t.Errorf("optimize(%q) wrong. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestOptimizePreservesResults(t *testing.T) {
+	tests := []string{
+		"const DAY = 60 * 60 * 24; DAY / 2",
+		"const A = 2; def f(A) { A * 10 }; f(5) + A",
+		"if (false) { 1 }",
+		"if (true) { var a = 1 }; var a = 2; a",
+		"def f(x) { if (false) { x }; if (true) { x + 1 } }; f(1)",
+		"def f() { if (true) { return 1 }; 2 }; f()",
+		"const A = 1; match (5) { A => A, _ => 0 }",
+		`"a" + "b" == "ab" ? "yes" : "no"`,
+		"const x = 1; if (true) { var f = def() { x }; var x = 2; f() }",
+		"const x = 1; if (true) { struct x { a }; x }",
+	}
+
+	for _, input := range tests {
+		expected := evaluator.Eval(parse(t, input), object.NewEnvironment())
+		optimized := evaluator.Eval(optimize(t, input), object.NewEnvironment())
+
+		if expected.Inspect() != optimized.Inspect() {
+			t.Errorf("optimize changed the result of %q. expected=%q, got=%q",
+				input, expected.Inspect(), optimized.Inspect())
+		}
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	return p.ParseProgram()
+}
@@ -11,8 +11,12 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
+	TERNARY
+	PIPE
 	EQUALS
 	LESSGREATER
+	RANGE
 	SUM
 	PRODUCT
 	PREFIX
@@ -26,16 +30,24 @@ type (
 )
 
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:   ASSIGN,
+	token.QUESTION: TERNARY,
+	token.PIPE:     PIPE,
+	token.RANGE:    RANGE,
+	token.RANGE_EQ: RANGE,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.IN:       LESSGREATER,
+	token.NOT:      LESSGREATER,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -60,6 +72,10 @@ type Parser struct {
 	peekToken token.Token
 	errors    []string
 
+	// scopes holds, for each enclosing block, the names bound in it and
+	// whether they are constants.
+	scopes []map[string]bool
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -91,16 +107,25 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.IN, p.parseInfixExpression)
+	p.registerInfix(token.RANGE, p.parseInfixExpression)
+	p.registerInfix(token.RANGE_EQ, p.parseInfixExpression)
+	p.registerInfix(token.NOT, p.parseNotInExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerInfix(token.DOT, p.parseDotExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PIPE, p.parsePipeExpression)
+	p.registerInfix(token.QUESTION, p.parseTernaryExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -135,6 +160,23 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseNotInExpression(left ast.Expression) ast.Expression {
+	expression := &ast.InfixExpression{
+		Token:    p.curToken,
+		Operator: "not in",
+		Left:     left,
+	}
+
+	precedence := p.curPrecedence()
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	expression.Right = p.parseExpression(precedence)
+
+	return expression
+}
+
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
 	p.nextToken()
@@ -145,18 +187,142 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		call := &ast.MethodCallExpression{Token: tok, Object: left, Method: name}
+		call.Arguments = p.parseExpressionList(token.RPAREN)
+		return call
+	}
+
+	return &ast.DotExpression{Token: tok, Left: left, Field: name}
+}
+
+func (p *Parser) parseTernaryExpression(condition ast.Expression) ast.Expression {
+	exp := &ast.TernaryExpression{Token: p.curToken, Condition: condition}
+
+	// COLON has no precedence, so the consequence stops right before it,
+	// which also keeps `{c ? a : b: v}` working inside hash literals.
+	p.nextToken()
+	exp.Consequence = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.COLON) {
+		return nil
+	}
+
+	// One below TERNARY so a ? b : c ? d : e nests to the right.
+	p.nextToken()
+	exp.Alternative = p.parseExpression(TERNARY - 1)
+
+	return exp
+}
+
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{Token: p.curToken, Target: target}
+
+	if _, ok := target.(*ast.DotExpression); !ok {
+		msg := fmt.Sprintf("Invalid assignment target: %s", target.String())
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	p.nextToken()
+	// Parse the right-hand side at LOWEST so chained assignments
+	// associate to the right: a.x = b.y = 1.
+	exp.Value = p.parseExpression(LOWEST)
+
+	return exp
+}
+
+// parsePipeExpression desugars `x |> f(a)` into `f(x, a)` and `x |> f`
+// into `f(x)`, so the evaluator only ever sees ordinary calls.
+func (p *Parser) parsePipeExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+
+	p.nextToken()
+	right := p.parseExpression(PIPE)
+
+	switch right := right.(type) {
+	case *ast.CallExpression:
+		args := append([]ast.Expression{left}, right.Arguments...)
+		return &ast.CallExpression{Token: right.Token, Function: right.Function, Arguments: args}
+	case *ast.MethodCallExpression:
+		args := append([]ast.Expression{left}, right.Arguments...)
+		return &ast.MethodCallExpression{Token: right.Token, Object: right.Object, Method: right.Method, Arguments: args}
+	case nil:
+		return nil
+	default:
+		return &ast.CallExpression{Token: tok, Function: right, Arguments: []ast.Expression{left}}
+	}
+}
+
+// parseGroupedExpression parses both parenthesised expressions and the
+// parameter list of an arrow function: (), (x) and (x, y) followed by =>.
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		return p.parseArrowFunction([]*ast.Identifier{})
+	}
+
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
+	if p.peekTokenIs(token.COMMA) {
+		params := []*ast.Identifier{p.lambdaParameter(exp)}
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			params = append(params, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		return p.parseArrowFunction(params)
+	}
+
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		return p.parseArrowFunction([]*ast.Identifier{p.lambdaParameter(exp)})
+	}
+
 	return exp
 }
 
+func (p *Parser) lambdaParameter(exp ast.Expression) *ast.Identifier {
+	ident, ok := exp.(*ast.Identifier)
+	if !ok {
+		var got string
+		if exp != nil {
+			got = exp.String()
+		}
+		msg := fmt.Sprintf("Invalid lambda parameter: %s", got)
+		p.errors = append(p.errors, msg)
+	}
+	return ident
+}
+
+func (p *Parser) parseArrowFunction(params []*ast.Identifier) ast.Expression {
+	lit := &ast.FunctionLiteral{Token: p.curToken, Parameters: params}
+	lit.Body = p.parseArrowBody()
+	return lit
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 
@@ -188,6 +354,70 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Arms = []*ast.MatchArm{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := &ast.MatchArm{Pattern: p.parseListElement()}
+
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			p.nextToken()
+			arm.Guard = p.parseExpression(LOWEST)
+		}
+
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+
+		arm.Body = p.parseArrowBody()
+		expression.Arms = append(expression.Arms, arm)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return expression
+}
+
+// parseArrowBody parses what follows => in a match arm or an arrow
+// function: a braced block, or a single expression whose value is the result.
+func (p *Parser) parseArrowBody() *ast.BlockStatement {
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		return p.parseBlockStatement()
+	}
+
+	p.nextToken()
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
+
+	return &ast.BlockStatement{Token: stmt.Token, Statements: []ast.Statement{stmt}}
+}
+
 //CALL EXPRESSION -<
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -242,6 +472,8 @@ func (p *Parser) nextToken() {
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
+	p.pushScope()
+	defer p.popScope()
 	for p.curToken.Type != token.EOF {
 		stmt := p.parseStatement()
 		if stmt != nil {
@@ -254,20 +486,78 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
+	constant := stmt.Token.Type == token.CONST
+
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+		for _, name := range patternNames(stmt.Pattern) {
+			p.declare(name.Value, constant)
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		p.declare(stmt.Name.Value, constant)
+	}
+
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseStructStatement() *ast.StructStatement {
+	stmt := &ast.StructStatement{Token: p.curToken}
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.declare(stmt.Name.Value, false)
 
-	if !p.expectPeek(token.ASSIGN) {
+	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
-	p.nextToken()
+	stmt.Fields = []*ast.Identifier{}
+	seen := make(map[string]bool)
 
-	stmt.Value = p.parseExpression(LOWEST)
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		field := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if seen[field.Value] {
+			msg := fmt.Sprintf("Duplicate field %s in struct %s", field.Value, stmt.Name.Value)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		seen[field.Value] = true
+		stmt.Fields = append(stmt.Fields, field)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -276,6 +566,131 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
+	stmt := &ast.FunctionStatement{Token: p.curToken}
+
+	stmt.Function = p.parseNamedFunctionLiteral()
+	if stmt.Function == nil {
+		return nil
+	}
+
+	stmt.Name = &ast.Identifier{
+		Token: token.Token{Type: token.IDENT, Literal: stmt.Function.Name},
+		Value: stmt.Function.Name,
+	}
+	p.declare(stmt.Name.Value, false)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseClassStatement() *ast.ClassStatement {
+	stmt := &ast.ClassStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.declare(stmt.Name.Value, false)
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Superclass = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Methods = []*ast.FunctionLiteral{}
+	seen := make(map[string]bool)
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+			continue
+		}
+		if !p.expectPeek(token.FUNCTION) {
+			return nil
+		}
+		method := p.parseNamedFunctionLiteral()
+		if method == nil {
+			return nil
+		}
+		if seen[method.Name] {
+			msg := fmt.Sprintf("Duplicate method %s in class %s", method.Name, stmt.Name.Value)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		seen[method.Name] = true
+		stmt.Methods = append(stmt.Methods, method)
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseNamedFunctionLiteral parses `def name(params) { body }`, as used by
+// function declarations and class methods.
+func (p *Parser) parseNamedFunctionLiteral() *ast.FunctionLiteral {
+	lit := &ast.FunctionLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	lit.Name = p.curToken.Literal
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	lit.Parameters, lit.Patterns = p.parseFunctionParameters()
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	lit.Body = p.parseBlockStatement()
+
+	return lit
+}
+
+func (p *Parser) pushScope() {
+	p.scopes = append(p.scopes, make(map[string]bool))
+}
+
+func (p *Parser) popScope() {
+	p.scopes = p.scopes[:len(p.scopes)-1]
+}
+
+// declare records a binding in the innermost block and reports rebinding a
+// constant declared in that same block. The evaluator enforces the same rule
+// at runtime for bindings it cannot see here, such as across REPL lines.
+func (p *Parser) declare(name string, constant bool) {
+	scope := p.scopes[len(p.scopes)-1]
+	if scope[name] {
+		msg := fmt.Sprintf("Cannot reassign constant: %s", name)
+		p.errors = append(p.errors, msg)
+		return
+	}
+	scope[name] = constant
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
@@ -306,6 +721,9 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
 
+	p.pushScope()
+	defer p.popScope()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
@@ -326,7 +744,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	lit.Parameters, lit.Patterns = p.parseFunctionParameters()
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -337,31 +755,126 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+// parseFunctionParameters returns the parameter list and, when any
+// parameter is an array or hash pattern, the patterns aligned with it. A
+// destructured parameter gets a placeholder identifier named after its
+// pattern, which can never clash with a real binding.
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, []ast.Expression) {
 	identifiers := []*ast.Identifier{}
+	var patterns []ast.Expression
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, nil
 	}
 
-	p.nextToken()
+	for {
+		p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+		switch p.curToken.Type {
+		case token.LBRACKET, token.LBRACE:
+			tok := p.curToken
+			pattern := p.parsePattern()
+			if pattern == nil {
+				return nil, nil
+			}
+			if patterns == nil {
+				patterns = make([]ast.Expression, len(identifiers))
+			}
+			patterns = append(patterns, pattern)
+			identifiers = append(identifiers, &ast.Identifier{Token: tok, Value: pattern.String()})
+		default:
+			ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			identifiers = append(identifiers, ident)
+			if patterns != nil {
+				patterns = append(patterns, nil)
+			}
+		}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 
 	if !p.expectPeek(token.RPAREN) {
+		return nil, nil
+	}
+
+	return identifiers, patterns
+}
+
+// parsePattern parses a destructuring target starting at [ or {.
+func (p *Parser) parsePattern() ast.Expression {
+	if p.curTokenIs(token.LBRACKET) {
+		return p.parseArrayPattern()
+	}
+	return p.parseHashPattern()
+}
+
+func (p *Parser) parseArrayPattern() ast.Expression {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+	pattern.Elements = []*ast.Identifier{}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
 
-	return identifiers
+	return pattern
+}
+
+func (p *Parser) parseHashPattern() ast.Expression {
+	pattern := &ast.HashPattern{Token: p.curToken}
+	pattern.Keys = []*ast.Identifier{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return pattern
+}
+
+// patternNames lists the identifiers a destructuring pattern binds.
+func patternNames(pattern ast.Expression) []*ast.Identifier {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		if pattern.Rest != nil {
+			return append(append([]*ast.Identifier{}, pattern.Elements...), pattern.Rest)
+		}
+		return pattern.Elements
+	case *ast.HashPattern:
+		return pattern.Keys
+	default:
+		return nil
+	}
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
@@ -396,13 +909,24 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			hash.Entries = append(hash.Entries, p.parseSpreadExpression())
+			if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+			continue
+		}
 		key := p.parseExpression(LOWEST)
+		if len(hash.Entries) == 0 && (p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE)) {
+			return p.parseSetLiteral(hash.Token, key)
+		}
 		if !p.expectPeek(token.COLON) {
 			return nil
 		}
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
 		hash.Pairs[key] = value
+		hash.Entries = append(hash.Entries, key)
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
 		}
@@ -413,6 +937,25 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+// parseSetLiteral continues a brace literal whose first element was not
+// followed by a colon, e.g. {1, 2, 3}. An empty {} stays a hash.
+func (p *Parser) parseSetLiteral(tok token.Token, first ast.Expression) ast.Expression {
+	set := &ast.SetLiteral{Token: tok}
+	set.Elements = []ast.Expression{first}
+
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		set.Elements = append(set.Elements, p.parseExpression(LOWEST))
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return set
+}
+
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 
@@ -422,12 +965,12 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	}
 
 	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
+	list = append(list, p.parseListElement())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		list = append(list, p.parseExpression(LOWEST))
+		list = append(list, p.parseListElement())
 	}
 
 	if !p.expectPeek(end) {
@@ -437,12 +980,37 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	return list
 }
 
+// parseListElement parses one call argument or array element, which may
+// be spread with a leading `...`.
+func (p *Parser) parseListElement() ast.Expression {
+	if p.curTokenIs(token.ELLIPSIS) {
+		return p.parseSpreadExpression()
+	}
+	return p.parseExpression(LOWEST)
+}
+
+func (p *Parser) parseSpreadExpression() *ast.SpreadExpression {
+	spread := &ast.SpreadExpression{Token: p.curToken}
+	p.nextToken()
+	spread.Value = p.parseExpression(LOWEST)
+	return spread
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.STRUCT:
+		return p.parseStructStatement()
+	case token.CLASS:
+		return p.parseClassStatement()
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseFunctionStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"squ1d/ast"
 	"squ1d/lexer"
+	"squ1d/token"
 	"testing"
 )
 
@@ -77,6 +78,104 @@ func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	return true
 }
 
+func TestConstStatements(t *testing.T) {
+	input := `const max = 10;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.LetStatement. Got %T", program.Statements[0])
+	}
+
+	if stmt.Token.Type != token.CONST {
+		t.Errorf("stmt.Token.Type is not CONST. Got %q", stmt.Token.Type)
+	}
+
+	testIdentifier(t, stmt.Name, "max")
+	testLiteralExpression(t, stmt.Value, 10)
+
+	if stmt.String() != "const max = 10;" {
+		t.Errorf("stmt.String() is wrong. Got %q", stmt.String())
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedPattern string
+		expectedNames   []string
+	}{
+		{"var [a, b] = pair;", "[a, b]", []string{"a", "b"}},
+		{"var [head, ...tail] = xs;", "[head, ...tail]", []string{"head", "tail"}},
+		{"var [...all] = xs;", "[...all]", []string{"all"}},
+		{"var [] = xs;", "[]", []string{}},
+		{"const {name, age} = user;", "{name, age}", []string{"name", "age"}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not *ast.LetStatement. Got %T", program.Statements[0])
+		}
+
+		if stmt.Pattern == nil {
+			t.Fatalf("stmt.Pattern is nil")
+		}
+
+		if stmt.Pattern.String() != tt.expectedPattern {
+			t.Errorf("stmt.Pattern is not %q. Got %q", tt.expectedPattern, stmt.Pattern.String())
+		}
+
+		names := patternNames(stmt.Pattern)
+		if len(names) != len(tt.expectedNames) {
+			t.Fatalf("Pattern binds %d names, expected %d", len(names), len(tt.expectedNames))
+		}
+		for i, name := range tt.expectedNames {
+			testIdentifier(t, names[i], name)
+		}
+	}
+}
+
+func TestDestructuringParameters(t *testing.T) {
+	input := "def([a, b], c, {d}) { a };"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	function := stmt.Expression.(*ast.FunctionLiteral)
+
+	if len(function.Parameters) != 3 || len(function.Patterns) != 3 {
+		t.Fatalf("Wrong parameters. Got %d parameters and %d patterns",
+			len(function.Parameters), len(function.Patterns))
+	}
+
+	if _, ok := function.Patterns[0].(*ast.ArrayPattern); !ok {
+		t.Errorf("Patterns[0] is not *ast.ArrayPattern. Got %T", function.Patterns[0])
+	}
+	if function.Patterns[1] != nil {
+		t.Errorf("Patterns[1] is not nil. Got %T", function.Patterns[1])
+	}
+	if _, ok := function.Patterns[2].(*ast.HashPattern); !ok {
+		t.Errorf("Patterns[2] is not *ast.HashPattern. Got %T", function.Patterns[2])
+	}
+
+	if function.String() != "def([a, b], c, {d}) a" {
+		t.Errorf("function.String() is wrong. Got %q", function.String())
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	input := `
 	return 5;
@@ -119,6 +218,176 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	t.FailNow()
 }
 
+func TestConstShadowing(t *testing.T) {
+	input := `
+const a = 1;
+def f() { var a = 2; a }
+var g = def() { const a = 3; a };
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
+func TestStructStatement(t *testing.T) {
+	input := `struct Point { x, y }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. Got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.StructStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.StructStatement. Got %T", program.Statements[0])
+	}
+
+	if stmt.Name.Value != "Point" {
+		t.Errorf("stmt.Name.Value is not 'Point'. Got %s", stmt.Name.Value)
+	}
+
+	if len(stmt.Fields) != 2 {
+		t.Fatalf("stmt.Fields does not contain 2 fields. Got %d", len(stmt.Fields))
+	}
+
+	testIdentifier(t, stmt.Fields[0], "x")
+	testIdentifier(t, stmt.Fields[1], "y")
+}
+
+func TestClassStatement(t *testing.T) {
+	input := `
+class Dog : Animal {
+	def init(name) { self.name = name; }
+	def speak() { super.speak() + "!" }
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. Got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ClassStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.ClassStatement. Got %T", program.Statements[0])
+	}
+
+	testIdentifier(t, stmt.Name, "Dog")
+	testIdentifier(t, stmt.Superclass, "Animal")
+
+	if len(stmt.Methods) != 2 {
+		t.Fatalf("stmt.Methods does not contain 2 methods. Got %d", len(stmt.Methods))
+	}
+
+	if stmt.Methods[0].Name != "init" || len(stmt.Methods[0].Parameters) != 1 {
+		t.Errorf("First method is wrong. Got %s", stmt.Methods[0].String())
+	}
+
+	call, ok := stmt.Methods[1].Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	if !ok {
+		t.Fatalf("speak body is not *ast.InfixExpression")
+	}
+	if _, ok := call.Left.(*ast.MethodCallExpression); !ok {
+		t.Errorf("call.Left is not *ast.MethodCallExpression. Got %T", call.Left)
+	}
+}
+
+func TestMethodCallExpressionParsing(t *testing.T) {
+	input := "obj.add(1, 2 * 3)"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	call, ok := stmt.Expression.(*ast.MethodCallExpression)
+	if !ok {
+		t.Fatalf("exp is not *ast.MethodCallExpression. Got %T", stmt.Expression)
+	}
+
+	testIdentifier(t, call.Object, "obj")
+	testIdentifier(t, call.Method, "add")
+
+	if len(call.Arguments) != 2 {
+		t.Fatalf("Wrong length of arguments. Got %d", len(call.Arguments))
+	}
+
+	testLiteralExpression(t, call.Arguments[0], 1)
+	testInfixExpression(t, call.Arguments[1], 2, "*", 3)
+}
+
+func TestFieldAssignmentParsing(t *testing.T) {
+	input := "p.x = p.y + 1"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	assign, ok := stmt.Expression.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("exp is not *ast.AssignExpression. Got %T", stmt.Expression)
+	}
+
+	target, ok := assign.Target.(*ast.DotExpression)
+	if !ok {
+		t.Fatalf("assign.Target is not *ast.DotExpression. Got %T", assign.Target)
+	}
+	testIdentifier(t, target.Left, "p")
+	testIdentifier(t, target.Field, "x")
+
+	if assign.Value.String() != "((p.y) + 1)" {
+		t.Errorf("assign.Value is not %q. Got %q", "((p.y) + 1)", assign.Value.String())
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "Invalid assignment target: x"},
+		{"struct P { a, a }", "Duplicate field a in struct P"},
+		{"class C { def f() {} def f() {} }", "Duplicate method f in class C"},
+		{"(1) => 1", "Invalid lambda parameter: 1"},
+		{"const a = 1; var a = 2;", "Cannot reassign constant: a"},
+		{"const a = 1; const a = 2;", "Cannot reassign constant: a"},
+		{"const f = 1; def f() {}", "Cannot reassign constant: f"},
+		{"def g() { const b = 1; var b = 2; }", "Cannot reassign constant: b"},
+		{"const [a, b] = x; var b = 1;", "Cannot reassign constant: b"},
+		{"var [a, ...b, c] = x;", "expected next token to be ], got , instead"},
+		{"var {1} = x;", "expected next token to be IDENT, got INT instead"},
+		{"(a, b)", "expected next token to be =>, got EOF instead"},
+		{"a ? b", "expected next token to be :, got EOF instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("Expected parser error for %q, got none", tt.input)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("Wrong parser error for %q. Expected %q, got %q", tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
 func TestIdentifierExpression(t *testing.T) {
 	input := "foobar;"
 
@@ -354,6 +623,77 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionStatement(t *testing.T) {
+	input := `def add(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. Got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.FunctionStatement. Got %T", program.Statements[0])
+	}
+
+	testIdentifier(t, stmt.Name, "add")
+
+	if stmt.Function.Name != "add" {
+		t.Errorf("stmt.Function.Name is not 'add'. Got %q", stmt.Function.Name)
+	}
+
+	if len(stmt.Function.Parameters) != 2 {
+		t.Fatalf("Function literal parameters wrong. Expected 2, got %d", len(stmt.Function.Parameters))
+	}
+
+	if stmt.String() != "def add(x, y) (x + y)" {
+		t.Errorf("stmt.String() is wrong. Got %q", stmt.String())
+	}
+}
+
+func TestArrowFunctionParsing(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedParams []string
+		expectedBody   string
+	}{
+		{"() => 1", []string{}, "1"},
+		{"(x) => x * 2", []string{"x"}, "(x * 2)"},
+		{"(x, y) => x + y", []string{"x", "y"}, "(x + y)"},
+		{"(x) => { var y = x; y }", []string{"x"}, "var y = x;y"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function, ok := stmt.Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression is not *ast.FunctionLiteral. Got %T", stmt.Expression)
+		}
+
+		if len(function.Parameters) != len(tt.expectedParams) {
+			t.Errorf("Length of parameters is wrong. Expected %d, got %d",
+				len(tt.expectedParams), len(function.Parameters))
+		}
+
+		for i, ident := range tt.expectedParams {
+			testLiteralExpression(t, function.Parameters[i], ident)
+		}
+
+		if function.Body.String() != tt.expectedBody {
+			t.Errorf("function.Body is not %q. Got %q", tt.expectedBody, function.Body.String())
+		}
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -523,6 +863,120 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingSetLiterals(t *testing.T) {
+	input := "{1, 2 * 2, x}"
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	set, ok := stmt.Expression.(*ast.SetLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.SetLiteral. Got %T", stmt.Expression)
+	}
+	if len(set.Elements) != 3 {
+		t.Fatalf("len(set.Elements) is not 3. Got %d", len(set.Elements))
+	}
+	testIntegerLiteral(t, set.Elements[0], 1)
+	testInfixExpression(t, set.Elements[1], 2, "*", 2)
+	testIdentifier(t, set.Elements[2], "x")
+}
+
+func TestParsingSpreadExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f(...args)", "f(...args)"},
+		{"f(a, ...b, c)", "f(a, ...b, c)"},
+		{"[0, ...xs, ...ys]", "[0, ...xs, ...ys]"},
+		{"o.m(...xs)", "o.m(...xs)"},
+		{"[...a + b]", "[...(a + b)]"},
+		{"{...defaults}", "{...defaults}"},
+		{"{...a, ...b}", "{...a, ...b}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { 1 => a, _ => b }", "matchx { 1 => a, _ => b }"},
+		{"match (x) { n if n > 1 => n * 2 }", "matchx { n if (n > 1) => (n * 2) }"},
+		{"match (x) { [a, ...rest] => rest }", "matchx { [a, ...rest] => rest }"},
+		{"match (x) { integer(n) => n, Point => 0, }", "matchx { integer(n) => n, Point => 0 }"},
+		{"match (x) { 1 => { var y = 2; y } }", "matchx { 1 => var y = 2;y }"},
+		{"match (x) {}", "matchx {  }"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. Got %d", len(program.Statements))
+		}
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.MatchExpression); !ok {
+			t.Fatalf("stmt.Expression is not *ast.MatchExpression. Got %T", stmt.Expression)
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestParsingHashLiteralWithSpreads(t *testing.T) {
+	input := `{...base, "a": 1, ...extra}`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.HashLiteral. Got %T", stmt.Expression)
+	}
+	if len(hash.Pairs) != 1 {
+		t.Errorf("hash.Pairs has wrong length. Got %d", len(hash.Pairs))
+	}
+	if len(hash.Entries) != 3 {
+		t.Fatalf("hash.Entries has wrong length. Got %d", len(hash.Entries))
+	}
+	for i, name := range []string{"base", "", "extra"} {
+		spread, ok := hash.Entries[i].(*ast.SpreadExpression)
+		if name == "" {
+			if ok {
+				t.Errorf("hash.Entries[%d] should be a key", i)
+			}
+			testLiteralExpression(t, hash.Pairs[hash.Entries[i]], 1)
+			continue
+		}
+		if !ok {
+			t.Fatalf("hash.Entries[%d] is not ast.SpreadExpression. Got %T", i, hash.Entries[i])
+		}
+		testIdentifier(t, spread.Value, name)
+	}
+	if hash.String() != `{...base, a:1, ...extra}` {
+		t.Errorf("hash.String() wrong. Got %q", hash.String())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -579,6 +1033,8 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"true == true", true, "==", true},
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
+		{"5 in x", 5, "in", "x"},
+		{"5 not in x", 5, "not in", "x"},
 	}
 
 	for _, tt := range infixTests {
@@ -777,6 +1233,110 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a + 1 in b == true",
+			"(((a + 1) in b) == true)",
+		},
+		{
+			"!a not in b",
+			"((!a) not in b)",
+		},
+		{
+			"a.b.c * d[0].e",
+			"(((a.b).c) * ((d[0]).e))",
+		},
+		{
+			"-p.x",
+			"(-(p.x))",
+		},
+		{
+			"a.b(c).d + 1",
+			"((a.b(c).d) + 1)",
+		},
+		{
+			"a |> f",
+			"f(a)",
+		},
+		{
+			"a + 1 |> f(b) |> g",
+			"g(f((a + 1), b))",
+		},
+		{
+			"s |> t.m(1) |> g",
+			"g(t.m(s, 1))",
+		},
+		{
+			"a == b |> f",
+			"f((a == b))",
+		},
+		{
+			"x < y + 1 |> f(z)",
+			"f((x < (y + 1)), z)",
+		},
+		{
+			"a |> f ? b : c",
+			"(f(a) ? b : c)",
+		},
+		{
+			"x |> def(y) { y }",
+			"def(y) y(x)",
+		},
+		{
+			"(a + b) * c",
+			"((a + b) * c)",
+		},
+		{
+			"map(xs, (x) => x * 2, 1)",
+			"map(xs, (x) => (x * 2), 1)",
+		},
+		{
+			"(x) => (y) => x + y",
+			"(x) => (y) => (x + y)",
+		},
+		{
+			"a < b ? a + 1 : b * 2",
+			"((a < b) ? (a + 1) : (b * 2))",
+		},
+		{
+			"a ? b : c ? d : e",
+			"(a ? b : (c ? d : e))",
+		},
+		{
+			"a ? b ? c : d : e",
+			"(a ? (b ? c : d) : e)",
+		},
+		{
+			"a == b ? c : d == e",
+			"((a == b) ? c : (d == e))",
+		},
+		{
+			"o.x = a ? 1 : 2",
+			"(o.x) = (a ? 1 : 2)",
+		},
+		{
+			"{a ? b : c: d}",
+			"{(a ? b : c):d}",
+		},
+		{
+			"{k: a ? b : c}",
+			"{k:(a ? b : c)}",
+		},
+		{
+			"1..n + 1",
+			"(1 .. (n + 1))",
+		},
+		{
+			"x in 0..=n * 2",
+			"(x in (0 ..= (n * 2)))",
+		},
+		{
+			"1..3 |> cat",
+			"cat((1 .. 3))",
+		},
+		{
+			"(0..10)[a - 1]",
+			"((0 .. 10)[(a - 1)])",
+		},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
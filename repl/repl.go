@@ -2,31 +2,36 @@ package repl
 
 import (
 	"bufio"
-	"fmt"
 	"io"
 	"os/user"
+	"squ1d/compiler"
 	"squ1d/evaluator"
 	"squ1d/lexer"
 	"squ1d/object"
 	"squ1d/parser"
+	"squ1d/resolver"
+	"squ1d/vm"
+	"strings"
 )
 
 const PROMPT = ">> "
 
 func Start(in io.Reader, out io.Writer) {
+	reader := bufio.NewReader(in)
+	runtime := newRuntime(reader, out)
 	env := object.NewEnvironment()
+	env.SetRuntime(runtime)
+	r := resolver.New(evaluator.BuiltinNames()...)
+	r.BlockScoping = runtime.BlockScoping()
 	_, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
-	scanner := bufio.NewScanner(in)
 	for {
-		fmt.Print(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, ok := readLine(reader, out)
+		if !ok {
 			return
 		}
-		line := scanner.Text()
 		l := lexer.New(line)
 		p := parser.New(l)
 		program := p.ParseProgram()
@@ -34,6 +39,10 @@ func Start(in io.Reader, out io.Writer) {
 			printParserErrors(out, p.Errors())
 			continue
 		}
+		if errors := r.Resolve(program); len(errors) != 0 {
+			printParserErrors(out, errors)
+			continue
+		}
 		evaluated := evaluator.Eval(program, env)
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
@@ -42,6 +51,68 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
+// StartVM is Start running each line on the bytecode vm. Globals and
+// constants carry over from one line to the next.
+func StartVM(in io.Reader, out io.Writer) {
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+	symbolTable := compiler.New().SymbolTable()
+
+	reader := bufio.NewReader(in)
+	runtime := newRuntime(reader, out)
+	for {
+		line, ok := readLine(reader, out)
+		if !ok {
+			return
+		}
+		l := lexer.New(line)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			printParserErrors(out, p.Errors())
+			continue
+		}
+
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			io.WriteString(out, "\t"+err.Error()+"\n")
+			continue
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		machine := vm.NewWithGlobalsStore(bytecode, globals)
+		machine.SetRuntime(runtime)
+		if err := machine.Run(); err != nil {
+			io.WriteString(out, "\t"+err.Error()+"\n")
+			continue
+		}
+		if result := machine.Result(); result != nil {
+			io.WriteString(out, result.Inspect())
+			io.WriteString(out, "\n")
+		}
+	}
+}
+
+// newRuntime has builtins read from the same reader as the REPL, so a
+// script's read gets the lines typed after it, and write to out.
+func newRuntime(reader *bufio.Reader, out io.Writer) *object.Runtime {
+	runtime := &object.Runtime{}
+	runtime.SetIO(reader, out, out)
+	return runtime
+}
+
+// readLine prompts for and reads the next line, reporting false once the
+// input is exhausted.
+func readLine(reader *bufio.Reader, out io.Writer) (string, bool) {
+	io.WriteString(out, PROMPT)
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	return strings.TrimRight(line, "\r\n"), true
+}
+
 func printParserErrors(out io.Writer, errors []string) {
 	for _, msg := range errors {
 		io.WriteString(out, "\t"+msg+"\n")
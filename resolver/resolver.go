@@ -0,0 +1,319 @@
+package resolver
+
+import (
+	"fmt"
+	"squ1d/ast"
+)
+
+// byName marks a binding that lives in an environment's name map rather
+// than in a slot: globals, struct and class names.
+const byName = -1
+
+// scope mirrors one object.Environment the evaluator will create: the
+// program's, one per function call, one per match arm and, with block
+// scoping, one per if/el body.
+type scope struct {
+	parent   *scope
+	names    map[string]int
+	numSlots int
+
+	// function is set on scopes whose function literals are resolved
+	// once the scope is complete: the program and function bodies.
+	function bool
+	pending  []pendingFunction
+}
+
+type pendingFunction struct {
+	literal *ast.FunctionLiteral
+	parent  *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, names: make(map[string]int)}
+}
+
+// owner is the nearest enclosing scope that collects function literals.
+func (s *scope) owner() *scope {
+	for !s.function {
+		s = s.parent
+	}
+	return s
+}
+
+// Resolver annotates identifiers that name local variables with the depth
+// and slot the evaluator will find them at, and reports identifiers that
+// name nothing. Globals stay name-based, so one Resolver can be reused
+// across REPL lines that share an environment.
+type Resolver struct {
+	// BlockScoping must match the runtime's BlockScoping, as it decides
+	// whether if/el bodies get their own environment.
+	BlockScoping bool
+
+	global *scope
+	errors []string
+}
+
+// New returns a resolver that treats globals, typically the builtins, as
+// already defined.
+func New(globals ...string) *Resolver {
+	global := newScope(nil)
+	global.function = true
+
+	r := &Resolver{BlockScoping: true, global: global}
+	r.Declare(globals...)
+	return r
+}
+
+// Declare adds globals defined outside the programs r resolves, such as
+// builtins or variables set by the host.
+func (r *Resolver) Declare(globals ...string) {
+	for _, name := range globals {
+		r.global.names[name] = byName
+	}
+}
+
+// Resolve annotates program and returns the identifiers it could not
+// resolve. Function bodies are resolved after the code around them, so they
+// may refer to variables declared further down.
+func (r *Resolver) Resolve(program *ast.Program) []string {
+	r.errors = []string{}
+
+	r.resolveStatements(program.Statements, r.global)
+	r.resolvePending(r.global)
+
+	return r.errors
+}
+
+func (r *Resolver) resolvePending(s *scope) {
+	for len(s.pending) > 0 {
+		fn := s.pending[0]
+		s.pending = s.pending[1:]
+		r.resolveFunction(fn.literal, fn.parent)
+	}
+}
+
+func (r *Resolver) resolveFunction(fl *ast.FunctionLiteral, parent *scope) {
+	s := newScope(parent)
+	s.function = true
+
+	for i, param := range fl.Parameters {
+		if fl.Patterns != nil && fl.Patterns[i] != nil {
+			r.declarePattern(fl.Patterns[i], s)
+			continue
+		}
+		r.declare(param, s)
+	}
+
+	r.resolveStatements(fl.Body.Statements, s)
+	r.resolvePending(s)
+}
+
+func (r *Resolver) resolveStatements(statements []ast.Statement, s *scope) {
+	// Function declarations are hoisted to the top of their block.
+	for _, statement := range statements {
+		if fs, ok := statement.(*ast.FunctionStatement); ok {
+			r.declare(fs.Name, s)
+			r.resolveExpression(fs.Function, s)
+		}
+	}
+
+	for _, statement := range statements {
+		r.resolveStatement(statement, s)
+	}
+}
+
+func (r *Resolver) resolveBlock(block *ast.BlockStatement, s *scope) {
+	if block == nil {
+		return
+	}
+	if r.BlockScoping {
+		s = newScope(s)
+	}
+	r.resolveStatements(block.Statements, s)
+}
+
+func (r *Resolver) resolveStatement(statement ast.Statement, s *scope) {
+	switch statement := statement.(type) {
+	case *ast.ExpressionStatement:
+		r.resolveExpression(statement.Expression, s)
+	case *ast.ReturnStatement:
+		r.resolveExpression(statement.ReturnValue, s)
+	case *ast.LetStatement:
+		r.resolveExpression(statement.Value, s)
+		if statement.Pattern != nil {
+			r.declarePattern(statement.Pattern, s)
+		} else {
+			r.declare(statement.Name, s)
+		}
+	case *ast.StructStatement:
+		s.names[statement.Name.Value] = byName
+	case *ast.ClassStatement:
+		if statement.Superclass != nil {
+			r.resolveExpression(statement.Superclass, s)
+		}
+		for _, method := range statement.Methods {
+			r.resolveExpression(method, s)
+		}
+		s.names[statement.Name.Value] = byName
+	}
+}
+
+func (r *Resolver) resolveExpression(expression ast.Expression, s *scope) {
+	switch node := expression.(type) {
+	case *ast.Identifier:
+		r.lookup(node, s)
+	case *ast.PrefixExpression:
+		r.resolveExpression(node.Right, s)
+	case *ast.InfixExpression:
+		r.resolveExpression(node.Left, s)
+		r.resolveExpression(node.Right, s)
+	case *ast.IfExpression:
+		r.resolveExpression(node.Condition, s)
+		r.resolveBlock(node.Consequence, s)
+		r.resolveBlock(node.Alternative, s)
+	case *ast.TernaryExpression:
+		r.resolveExpression(node.Condition, s)
+		r.resolveExpression(node.Consequence, s)
+		r.resolveExpression(node.Alternative, s)
+	case *ast.FunctionLiteral:
+		owner := s.owner()
+		owner.pending = append(owner.pending, pendingFunction{literal: node, parent: s})
+	case *ast.CallExpression:
+		r.resolveExpression(node.Function, s)
+		r.resolveExpressions(node.Arguments, s)
+	case *ast.MethodCallExpression:
+		r.resolveExpression(node.Object, s)
+		r.resolveExpressions(node.Arguments, s)
+	case *ast.DotExpression:
+		r.resolveExpression(node.Left, s)
+	case *ast.AssignExpression:
+		r.resolveExpression(node.Target, s)
+		r.resolveExpression(node.Value, s)
+	case *ast.IndexExpression:
+		r.resolveExpression(node.Left, s)
+		r.resolveExpression(node.Index, s)
+	case *ast.ArrayLiteral:
+		r.resolveExpressions(node.Elements, s)
+	case *ast.SetLiteral:
+		r.resolveExpressions(node.Elements, s)
+	case *ast.SpreadExpression:
+		r.resolveExpression(node.Value, s)
+	case *ast.HashLiteral:
+		for _, key := range node.Entries {
+			r.resolveExpression(key, s)
+			if value, ok := node.Pairs[key]; ok {
+				r.resolveExpression(value, s)
+			}
+		}
+	case *ast.MatchExpression:
+		r.resolveExpression(node.Subject, s)
+		for _, arm := range node.Arms {
+			armScope := newScope(s)
+			r.resolveMatchPattern(arm.Pattern, armScope)
+			if arm.Guard != nil {
+				r.resolveExpression(arm.Guard, armScope)
+			}
+			r.resolveStatements(arm.Body.Statements, armScope)
+		}
+	}
+}
+
+func (r *Resolver) resolveExpressions(expressions []ast.Expression, s *scope) {
+	for _, e := range expressions {
+		r.resolveExpression(e, s)
+	}
+}
+
+// resolveMatchPattern follows the evaluator's matchPattern: bare names
+// bind, hash keys and type pattern names are taken literally, and anything
+// else is an expression.
+func (r *Resolver) resolveMatchPattern(pattern ast.Expression, s *scope) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			r.declare(pattern, s)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range pattern.Elements {
+			if spread, ok := el.(*ast.SpreadExpression); ok {
+				r.resolveMatchPattern(spread.Value, s)
+				continue
+			}
+			r.resolveMatchPattern(el, s)
+		}
+	case *ast.HashLiteral:
+		for _, key := range pattern.Entries {
+			if _, ok := key.(*ast.Identifier); !ok {
+				r.resolveExpression(key, s)
+			}
+			r.resolveMatchPattern(pattern.Pairs[key], s)
+		}
+	case *ast.CallExpression:
+		if _, ok := pattern.Function.(*ast.Identifier); !ok {
+			r.resolveExpression(pattern, s)
+			return
+		}
+		for _, arg := range pattern.Arguments {
+			r.resolveMatchPattern(arg, s)
+		}
+	default:
+		r.resolveExpression(pattern, s)
+	}
+}
+
+func (r *Resolver) declarePattern(pattern ast.Expression, s *scope) {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		for _, name := range pattern.Elements {
+			r.declare(name, s)
+		}
+		if pattern.Rest != nil {
+			r.declare(pattern.Rest, s)
+		}
+	case *ast.HashPattern:
+		for _, key := range pattern.Keys {
+			r.declare(key, s)
+		}
+	}
+}
+
+// declare gives ident a slot in s. Redeclaring a name in the same scope
+// reuses its slot, as the evaluator overwrites the binding. Names declared
+// at the top level stay name-based.
+func (r *Resolver) declare(ident *ast.Identifier, s *scope) {
+	if s == r.global {
+		s.names[ident.Value] = byName
+		ident.Resolved = false
+		return
+	}
+
+	slot, ok := s.names[ident.Value]
+	if !ok || slot == byName {
+		slot = s.numSlots
+		s.numSlots++
+		s.names[ident.Value] = slot
+	}
+
+	ident.Resolved = true
+	ident.Depth = 0
+	ident.Slot = slot
+}
+
+func (r *Resolver) lookup(ident *ast.Identifier, s *scope) {
+	// self and super are bound by name when a method is called.
+	if ident.Value == "self" || ident.Value == "super" {
+		return
+	}
+
+	for depth := 0; s != nil; depth++ {
+		if slot, ok := s.names[ident.Value]; ok {
+			ident.Resolved = slot != byName
+			ident.Depth = depth
+			ident.Slot = slot
+			return
+		}
+		s = s.parent
+	}
+
+	r.errors = append(r.errors, fmt.Sprintf("Identifier not found: %s", ident.Value))
+}
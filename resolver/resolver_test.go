@@ -0,0 +1,166 @@
+package resolver
+
+import (
+	"squ1d/ast"
+	"squ1d/lexer"
+	"squ1d/parser"
+	"testing"
+)
+
+// resolve parses input and resolves it with r.
+func resolve(t *testing.T, r *Resolver, input string) (*ast.Program, []string) {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	return program, r.Resolve(program)
+}
+
+func TestResolveLocals(t *testing.T) {
+	input := `
+var g = 1;
+var f = def(a, b) {
+	var c = a + b;
+	if (c > g) {
+		var d = c;
+		def() { d + a }
+	}
+};`
+
+	program, errors := resolve(t, New(), input)
+	if len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	fn := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements
+
+	testSlot(t, fn.Parameters[0], 0, 0)
+	testSlot(t, fn.Parameters[1], 0, 1)
+
+	c := body[0].(*ast.LetStatement)
+	testSlot(t, c.Name, 0, 2)
+	sum := c.Value.(*ast.InfixExpression)
+	testSlot(t, sum.Left.(*ast.Identifier), 0, 0)
+	testSlot(t, sum.Right.(*ast.Identifier), 0, 1)
+
+	ifExp := body[1].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	cond := ifExp.Condition.(*ast.InfixExpression)
+	testSlot(t, cond.Left.(*ast.Identifier), 0, 2)
+	testGlobal(t, cond.Right.(*ast.Identifier))
+
+	d := ifExp.Consequence.Statements[0].(*ast.LetStatement)
+	testSlot(t, d.Name, 0, 0)
+	testSlot(t, d.Value.(*ast.Identifier), 1, 2)
+
+	inner := ifExp.Consequence.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	innerSum := inner.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression)
+	testSlot(t, innerSum.Left.(*ast.Identifier), 1, 0)
+	testSlot(t, innerSum.Right.(*ast.Identifier), 2, 0)
+}
+
+func TestResolveWithoutBlockScoping(t *testing.T) {
+	r := New()
+	r.BlockScoping = false
+
+	program, errors := resolve(t, r, "def() { var a = 1; if (true) { var b = a; b } }")
+	if len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	ifExp := fn.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	b := ifExp.Consequence.Statements[0].(*ast.LetStatement)
+
+	testSlot(t, b.Name, 0, 1)
+	testSlot(t, b.Value.(*ast.Identifier), 0, 0)
+}
+
+func TestResolveMatchArms(t *testing.T) {
+	program, errors := resolve(t, New(), "def(x) { match (x) { [h, ...t] if h > 0 => t, {k: v} => v, integer(n) => n } }")
+	if len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	match := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+
+	testSlot(t, match.Subject.(*ast.Identifier), 0, 0)
+
+	first := match.Arms[0]
+	testSlot(t, first.Guard.(*ast.InfixExpression).Left.(*ast.Identifier), 0, 0)
+	testSlot(t, first.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.Identifier), 0, 1)
+
+	third := match.Arms[2]
+	testSlot(t, third.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.Identifier), 0, 0)
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"x", []string{"Identifier not found: x"}},
+		{"cat(1)", []string{}},
+		{"var f = def() { later }; var later = 1;", []string{}},
+		{"f(); def f() { g() } def g() { 1 }", []string{}},
+		{"def() { a; var a = 1; }", []string{"Identifier not found: a"}},
+		{"if (true) { var y = 1 }; y", []string{"Identifier not found: y"}},
+		{"def(x) { match (x) { n => n } ; n }", []string{"Identifier not found: n"}},
+		{"struct P { x }; P(1).x", []string{}},
+		{"class A { def m() { self.x + super.m() } }", []string{}},
+		{"def([a, ...b], {c}) { a + b + c }", []string{}},
+		{"var [a, b] = [1, 2]; a + b", []string{}},
+		{"def() { missing(1) }", []string{"Identifier not found: missing"}},
+	}
+
+	for _, tt := range tests {
+		_, errors := resolve(t, New("cat"), tt.input)
+		if len(errors) != len(tt.expected) {
+			t.Errorf("%q: expected errors %v, got %v", tt.input, tt.expected, errors)
+			continue
+		}
+		for i, msg := range tt.expected {
+			if errors[i] != msg {
+				t.Errorf("%q: expected %q, got %q", tt.input, msg, errors[i])
+			}
+		}
+	}
+}
+
+func TestResolveAcrossPrograms(t *testing.T) {
+	r := New()
+
+	if _, errors := resolve(t, r, "var a = 1;"); len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if _, errors := resolve(t, r, "a + 1"); len(errors) != 0 {
+		t.Errorf("global from an earlier program not found: %v", errors)
+	}
+}
+
+func testSlot(t *testing.T, ident *ast.Identifier, depth, slot int) {
+	t.Helper()
+
+	if !ident.Resolved {
+		t.Errorf("%s is not resolved", ident.Value)
+		return
+	}
+	if ident.Depth != depth || ident.Slot != slot {
+		t.Errorf("%s resolved to (%d, %d), expected (%d, %d)",
+			ident.Value, ident.Depth, ident.Slot, depth, slot)
+	}
+}
+
+func testGlobal(t *testing.T, ident *ast.Identifier) {
+	t.Helper()
+
+	if ident.Resolved {
+		t.Errorf("global %s resolved to (%d, %d)", ident.Value, ident.Depth, ident.Slot)
+	}
+}
@@ -0,0 +1,260 @@
+// Package squ1d embeds the SQU1D interpreter in Go programs.
+//
+//	interp := squ1d.New(squ1d.WithStdout(&out), squ1d.WithMaxSteps(100000))
+//	result, err := interp.Eval(`var x = 40; x + 2`)
+//
+// Globals defined by one call to Eval are visible to the next, as in the
+// REPL.
+package squ1d
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"squ1d/evaluator"
+	"squ1d/lexer"
+	"squ1d/object"
+	"squ1d/parser"
+	"squ1d/resolver"
+	"strings"
+)
+
+// Capability names a group of builtins that reach outside the interpreter.
+type Capability = evaluator.Capability
+
+const (
+	CapabilityIO      = evaluator.CapabilityIO
+	CapabilityFS      = evaluator.CapabilityFS
+	CapabilityNet     = evaluator.CapabilityNet
+	CapabilityTime    = evaluator.CapabilityTime
+	CapabilityRandom  = evaluator.CapabilityRandom
+	CapabilityProcess = evaluator.CapabilityProcess
+)
+
+var (
+	// ErrStepLimit is the Cause of the error a program stops with once it
+	// has made more calls than WithMaxSteps allows.
+	ErrStepLimit = object.ErrStepLimit
+	// ErrMemoryLimit is the Cause of the error a program stops with once
+	// it has allocated more than WithMemoryLimit allows.
+	ErrMemoryLimit = object.ErrMemoryLimit
+	// ErrCallDepth is the Cause of the error a program stops with once its
+	// calls nest deeper than WithMaxCallDepth allows.
+	ErrCallDepth = object.ErrCallDepth
+)
+
+// SyntaxError lists the problems found in a program before it ran.
+type SyntaxError struct {
+	Messages []string
+}
+
+func (e *SyntaxError) Error() string {
+	return strings.Join(e.Messages, "; ")
+}
+
+// Error is an error raised while a program ran. Errors raised by the
+// interpreter rather than the program, such as a cancelled context or an
+// exceeded limit, have a Cause.
+type Error struct {
+	Message string
+	// Trace lists the named functions the error propagated out of,
+	// innermost first.
+	Trace []string
+	Cause error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// BuiltinFunc is a Go function scripts can call. An error it returns is
+// raised in the script as a runtime error.
+type BuiltinFunc func(args ...Value) (Value, error)
+
+// Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// WithStdin sets where the read builtin reads from. It defaults to the
+// process's standard input.
+func WithStdin(in io.Reader) Option {
+	return func(i *Interpreter) { i.stdin = in }
+}
+
+// WithStdout sets where the write builtin writes to. It defaults to the
+// process's standard output.
+func WithStdout(out io.Writer) Option {
+	return func(i *Interpreter) { i.stdout = out }
+}
+
+// WithStderr sets the error stream handed to builtins. It defaults to the
+// process's standard error.
+func WithStderr(errOut io.Writer) Option {
+	return func(i *Interpreter) { i.stderr = errOut }
+}
+
+// WithCapabilities limits the builtins scripts can call to those needing
+// no capability and those in the granted groups. By default every group is
+// granted.
+func WithCapabilities(granted ...Capability) Option {
+	return func(i *Interpreter) { i.capabilities = granted }
+}
+
+// WithMaxSteps stops each evaluation with ErrStepLimit once it has made
+// more than steps calls.
+func WithMaxSteps(steps int64) Option {
+	return func(i *Interpreter) { i.maxSteps = steps }
+}
+
+// WithMemoryLimit stops each evaluation with ErrMemoryLimit once it has
+// allocated roughly more than bytes for strings, arrays, hashes and sets.
+func WithMemoryLimit(bytes int64) Option {
+	return func(i *Interpreter) { i.maxMemory = bytes }
+}
+
+// WithMaxCallDepth stops each evaluation with ErrCallDepth once its calls
+// nest more than depth deep. It defaults to 10000.
+func WithMaxCallDepth(depth int) Option {
+	return func(i *Interpreter) { i.maxDepth = depth }
+}
+
+// WithBlockScoping decides whether every if/el body gets its own scope, so
+// variables declared inside a block are not visible after it. It is on by
+// default; turning it off saves an allocation per block.
+func WithBlockScoping(enabled bool) Option {
+	return func(i *Interpreter) { i.blockScoping = enabled }
+}
+
+// Interpreter runs SQU1D programs against a shared set of globals. It is
+// not safe for concurrent use.
+type Interpreter struct {
+	env      *object.Environment
+	runtime  *object.Runtime
+	resolver *resolver.Resolver
+	builtins map[string]*object.Builtin
+
+	stdin        io.Reader
+	stdout       io.Writer
+	stderr       io.Writer
+	capabilities []Capability
+	maxSteps     int64
+	maxMemory    int64
+	maxDepth     int
+	blockScoping bool
+}
+
+// New returns an interpreter with no globals besides the builtins.
+func New(options ...Option) *Interpreter {
+	i := &Interpreter{capabilities: evaluator.Capabilities, blockScoping: true}
+	for _, option := range options {
+		option(i)
+	}
+
+	i.builtins = evaluator.Builtins(i.capabilities...)
+
+	i.runtime = &object.Runtime{}
+	i.runtime.SetBuiltins(i.builtins)
+	i.runtime.SetIO(i.stdin, i.stdout, i.stderr)
+	i.runtime.LimitMemory(i.maxMemory)
+	i.runtime.LimitDepth(i.maxDepth)
+	i.runtime.SetBlockScoping(i.blockScoping)
+
+	i.env = object.NewEnvironment()
+	i.env.SetRuntime(i.runtime)
+
+	names := make([]string, 0, len(i.builtins))
+	for name := range i.builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	i.resolver = resolver.New(names...)
+	i.resolver.BlockScoping = i.blockScoping
+
+	return i
+}
+
+// Eval runs src and returns the value of its last statement.
+func (i *Interpreter) Eval(src string) (Value, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+// EvalContext is Eval, stopping the program with an error whose Cause is
+// ctx.Err() once ctx is done. A panic while the program runs, such as in a
+// Go function it calls, is returned as an *Error rather than crashing the
+// host.
+func (i *Interpreter) EvalContext(ctx context.Context, src string) (result Value, err error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return Null, &SyntaxError{Messages: p.Errors()}
+	}
+	if errors := i.resolver.Resolve(program); len(errors) != 0 {
+		return Null, &SyntaxError{Messages: errors}
+	}
+
+	i.runtime.Limit(ctx, i.maxSteps)
+	defer i.runtime.Release()
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = Null, &Error{Message: fmt.Sprintf("Internal error: %v", r)}
+		}
+	}()
+
+	evaluated := evaluator.Eval(program, i.env)
+	if err, ok := evaluated.(*object.Error); ok {
+		return Null, &Error{Message: err.Message, Trace: err.Trace, Cause: err.Cause}
+	}
+	return valueOf(evaluated), nil
+}
+
+// RunFile runs the program in the file at path.
+func (i *Interpreter) RunFile(path string) (Value, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Null, err
+	}
+	return i.Eval(string(data))
+}
+
+// Set binds the global name to value, failing if name is a constant.
+func (i *Interpreter) Set(name string, value Value) error {
+	if err, ok := i.env.Set(name, value.Object()).(*object.Error); ok {
+		return &Error{Message: err.Message}
+	}
+	i.resolver.Declare(name)
+	return nil
+}
+
+// Get returns the value of the global name.
+func (i *Interpreter) Get(name string) (Value, bool) {
+	obj, ok := i.env.Get(name)
+	if !ok {
+		return Null, false
+	}
+	return valueOf(obj), true
+}
+
+// RegisterBuiltin makes fn callable from scripts as name, whatever
+// capabilities the interpreter was given.
+func (i *Interpreter) RegisterBuiltin(name string, fn BuiltinFunc) {
+	i.builtins[name] = &object.Builtin{
+		Fn: func(env *object.Environment, args ...object.Object) object.Object {
+			values := make([]Value, len(args))
+			for idx, arg := range args {
+				values[idx] = valueOf(arg)
+			}
+
+			result, err := fn(values...)
+			if err != nil {
+				return &object.Error{Message: fmt.Sprintf("%s: %s", name, err)}
+			}
+			return result.Object()
+		},
+	}
+	i.resolver.Declare(name)
+}
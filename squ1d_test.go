@@ -0,0 +1,253 @@
+package squ1d
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	interp := New()
+
+	result, err := interp.Eval("var x = 40; x + 2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n, ok := result.Int(); !ok || n != 42 {
+		t.Errorf("wrong result. got=%s (%s)", result, result.Type())
+	}
+
+	// Globals carry over from one call to the next.
+	result, err = interp.Eval(`def greet(name) { "hi " + name }; greet("x" + tp(x))`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s, ok := result.Str(); !ok || s != "hi xinteger" {
+		t.Errorf("wrong result. got=%s", result)
+	}
+
+	result, err = interp.Eval("var y = 1")
+	if err != nil || !result.IsNull() {
+		t.Errorf("expected null. got=%s, %v", result, err)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	interp := New()
+
+	_, err := interp.Eval("var = 1")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected a *SyntaxError. got=%T (%v)", err, err)
+	}
+
+	_, err = interp.Eval("undefined + 1")
+	if !errors.As(err, &syntaxErr) || syntaxErr.Messages[0] != "Identifier not found: undefined" {
+		t.Errorf("expected an unresolved identifier. got=%v", err)
+	}
+
+	_, err = interp.Eval(`def f() { 1 + "a" }; f()`)
+	var runtimeErr *Error
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected an *Error. got=%T (%v)", err, err)
+	}
+	if runtimeErr.Message != "Type mismatch: INTEGER + STRING" || len(runtimeErr.Trace) != 1 || runtimeErr.Trace[0] != "f" {
+		t.Errorf("wrong error. got=%q in %v", runtimeErr.Message, runtimeErr.Trace)
+	}
+}
+
+func TestEvalDoesNotPanic(t *testing.T) {
+	interp := New()
+	interp.RegisterBuiltin("boom", func(args ...Value) (Value, error) {
+		panic("boom")
+	})
+
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"var a = 0; 1 / a", "Division by zero"},
+		{"def f(x) { x }; f()", "Wrong number of arguments to f. Got 0, expected 1"},
+		{"boom()", "Internal error: boom"},
+	}
+
+	for _, tt := range tests {
+		_, err := interp.Eval(tt.input)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q. got=%v", tt.input, tt.err, err)
+		}
+	}
+
+	if result, err := interp.Eval("f(1)"); err != nil || result.String() != "1" {
+		t.Errorf("the interpreter should be usable after a panic. got=%s, %v", result, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	forever := "def f(n) { f(n + 1) }; f(0)"
+
+	_, err := New(WithMaxSteps(1000)).Eval(forever)
+	if !errors.Is(err, ErrStepLimit) {
+		t.Errorf("expected ErrStepLimit. got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = New().EvalContext(ctx, forever)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled. got=%v", err)
+	}
+
+	_, err = New(WithMaxSteps(1 << 40)).Eval(forever)
+	if !errors.Is(err, ErrCallDepth) {
+		t.Errorf("expected ErrCallDepth. got=%v", err)
+	}
+	_, err = New(WithMaxCallDepth(10)).Eval("def g(n) { if (n > 0) { g(n - 1) } }; g(10)")
+	if !errors.Is(err, ErrCallDepth) {
+		t.Errorf("expected ErrCallDepth. got=%v", err)
+	}
+
+	_, err = New(WithMemoryLimit(1024)).Eval(`[...1..1000]`)
+	if !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("expected ErrMemoryLimit. got=%v", err)
+	}
+
+	// Steps are counted per evaluation, and the count of the last one is
+	// kept after it.
+	interp := New(WithMaxSteps(3))
+	interp.Eval("def f() { 1 }")
+	for i := 0; i < 5; i++ {
+		if _, err := interp.Eval("f(); f()"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if steps := interp.runtime.Steps(); steps != 2 {
+		t.Errorf("wrong step count. got=%d", steps)
+	}
+	interp.Eval(`"a" + "b"`)
+	if interp.runtime.Allocated() == 0 {
+		t.Errorf("allocations should be counted")
+	}
+}
+
+func TestIO(t *testing.T) {
+	var out bytes.Buffer
+	interp := New(WithStdin(strings.NewReader("5\n")), WithStdout(&out))
+
+	if _, err := interp.Eval(`var n = read("n: "); write(n * 2)`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "n: 10\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	interp := New(WithCapabilities())
+
+	_, err := interp.Eval(`write("hi")`)
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Messages[0] != "Identifier not found: write" {
+		t.Errorf("write should not be available. got=%v", err)
+	}
+
+	if _, err := interp.Eval(`cat("abc")`); err != nil {
+		t.Errorf("cat should be available. got=%v", err)
+	}
+}
+
+func TestBlockScoping(t *testing.T) {
+	src := "var x = 1; if (true) { var x = 2; var y = 3 }; x"
+
+	result, err := New().Eval(src)
+	if n, _ := result.Int(); err != nil || n != 1 {
+		t.Errorf("blocks should be scoped by default. got=%s, %v", result, err)
+	}
+
+	interp := New(WithBlockScoping(false))
+	result, err = interp.Eval(src)
+	if n, _ := result.Int(); err != nil || n != 2 {
+		t.Errorf("blocks should share the enclosing scope. got=%s, %v", result, err)
+	}
+	if y, ok := interp.Get("y"); !ok || y.String() != "3" {
+		t.Errorf("y should leak out of the block. got=%s, %v", y, ok)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	interp := New()
+
+	if err := interp.Set("limit", Int(10)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := interp.Set("names", Array(String("a"), String("b"))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result, err := interp.Eval("var doubled = limit * 2; cat(names)")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n, _ := result.Int(); n != 2 {
+		t.Errorf("wrong result. got=%s", result)
+	}
+
+	doubled, ok := interp.Get("doubled")
+	if n, _ := doubled.Int(); !ok || n != 20 {
+		t.Errorf("wrong doubled. got=%s", doubled)
+	}
+	if _, ok := interp.Get("missing"); ok {
+		t.Errorf("missing should not be set")
+	}
+
+	interp.Eval("const fixed = 1")
+	if err := interp.Set("fixed", Int(2)); err == nil || err.Error() != "Cannot reassign constant: fixed" {
+		t.Errorf("expected a constant error. got=%v", err)
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	interp := New(WithCapabilities())
+	interp.RegisterBuiltin("sum", func(args ...Value) (Value, error) {
+		var total int64
+		for _, arg := range args {
+			n, ok := arg.Int()
+			if !ok {
+				return Null, fmt.Errorf("expected integers, got %s", arg.Type())
+			}
+			total += n
+		}
+		return Int(total), nil
+	})
+
+	result, err := interp.Eval("sum(1, 2, 3)")
+	if n, _ := result.Int(); err != nil || n != 6 {
+		t.Errorf("wrong result. got=%s, %v", result, err)
+	}
+
+	_, err = interp.Eval(`sum(1, "a")`)
+	if err == nil || err.Error() != "sum: expected integers, got string" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.sqd")
+	if err := ioutil.WriteFile(path, []byte("var a = [1, 2]; a[1]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := New().RunFile(path)
+	if n, _ := result.Int(); err != nil || n != 2 {
+		t.Errorf("wrong result. got=%s, %v", result, err)
+	}
+
+	if _, err := New().RunFile(filepath.Join(t.TempDir(), "missing.sqd")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
@@ -38,6 +38,19 @@ const (
 	LBRACKET  = "["
 	RBRACKET  = "]"
 	COLON     = ":"
+	IN        = "IN"
+	NOT       = "NOT"
+	DOT       = "."
+	STRUCT    = "STRUCT"
+	CLASS     = "CLASS"
+	PIPE      = "|>"
+	ARROW     = "=>"
+	CONST     = "CONST"
+	ELLIPSIS  = "..."
+	MATCH     = "MATCH"
+	QUESTION  = "?"
+	RANGE     = ".."
+	RANGE_EQ  = "..="
 )
 
 var keywords = map[string]TokenType{
@@ -48,6 +61,12 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"el":     ELSE,
 	"return": RETURN,
+	"in":     IN,
+	"not":    NOT,
+	"struct": STRUCT,
+	"class":  CLASS,
+	"const":  CONST,
+	"match":  MATCH,
 }
 
 func LookupIdent(ident string) TokenType {
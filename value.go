@@ -0,0 +1,112 @@
+package squ1d
+
+import (
+	"squ1d/evaluator"
+	"squ1d/object"
+)
+
+// Value is a SQU1D value passed between scripts and the host.
+type Value struct {
+	obj object.Object
+}
+
+// Null is the value of statements that produce nothing.
+var Null = Value{obj: evaluator.NULL}
+
+func valueOf(obj object.Object) Value {
+	if obj == nil {
+		return Null
+	}
+	return Value{obj: obj}
+}
+
+// Int returns an integer value.
+func Int(n int64) Value {
+	return Value{obj: object.NewInteger(n)}
+}
+
+// String returns a string value.
+func String(s string) Value {
+	return Value{obj: &object.String{Value: s}}
+}
+
+// Bool returns a boolean value.
+func Bool(b bool) Value {
+	if b {
+		return Value{obj: evaluator.TRUE}
+	}
+	return Value{obj: evaluator.FALSE}
+}
+
+// Array returns an array holding elements.
+func Array(elements ...Value) Value {
+	objects := make([]object.Object, len(elements))
+	for i, el := range elements {
+		objects[i] = el.Object()
+	}
+	return Value{obj: &object.Array{Elements: objects}}
+}
+
+// Type returns the name the tp builtin gives the value's type, such as
+// "integer" or "array".
+func (v Value) Type() string {
+	return evaluator.TypeName(v.Object())
+}
+
+// IsNull reports whether v is null.
+func (v Value) IsNull() bool {
+	return v.Object() == evaluator.NULL
+}
+
+// Int returns the value of an integer.
+func (v Value) Int() (int64, bool) {
+	integer, ok := v.obj.(*object.Integer)
+	if !ok {
+		return 0, false
+	}
+	return integer.Value, true
+}
+
+// Str returns the value of a string.
+func (v Value) Str() (string, bool) {
+	str, ok := v.obj.(*object.String)
+	if !ok {
+		return "", false
+	}
+	return str.Value, true
+}
+
+// Bool returns the value of a boolean.
+func (v Value) Bool() (bool, bool) {
+	b, ok := v.obj.(*object.Boolean)
+	if !ok {
+		return false, false
+	}
+	return b.Value, true
+}
+
+// Elements returns the elements of an array.
+func (v Value) Elements() ([]Value, bool) {
+	arr, ok := v.obj.(*object.Array)
+	if !ok {
+		return nil, false
+	}
+	elements := make([]Value, len(arr.Elements))
+	for i, el := range arr.Elements {
+		elements[i] = valueOf(el)
+	}
+	return elements, true
+}
+
+// String returns the value as the REPL prints it.
+func (v Value) String() string {
+	return v.Object().Inspect()
+}
+
+// Object returns the interpreter's representation of v.
+func (v Value) Object() object.Object {
+	if v.obj == nil {
+		return evaluator.NULL
+	}
+	return v.obj
+}
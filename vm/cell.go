@@ -0,0 +1,17 @@
+package vm
+
+import "squ1d/object"
+
+// cell holds a local that a closure captured before it was assigned, so the
+// closure sees the value once it is. A nil value means it is unassigned.
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+func (c *cell) Inspect() string {
+	if c.value == nil {
+		return "cell"
+	}
+	return "cell " + c.value.Inspect()
+}
@@ -0,0 +1,227 @@
+package vm
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"squ1d/compiler"
+	"squ1d/evaluator"
+	"squ1d/lexer"
+	"squ1d/object"
+	squ1dparser "squ1d/parser"
+	"squ1d/resolver"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestEvaluatorConformance runs every program the evaluator's tests pass to
+// testEval through both engines and checks that they agree. The programs
+// are read from evaluator_test.go itself, so a case added there is covered
+// here without being copied.
+func TestEvaluatorConformance(t *testing.T) {
+	programs := evaluatorPrograms(t, "../evaluator/evaluator_test.go")
+	if len(programs) < 200 {
+		t.Fatalf("expected to find the evaluator's programs, found %d", len(programs))
+	}
+
+	for _, input := range programs {
+		expected := describe(evaluate(input))
+
+		comp := compiler.New()
+		if err := comp.Compile(squ1dparser.New(lexer.New(input)).ParseProgram()); err != nil {
+			t.Errorf("compile error for %q: %s", input, err)
+			continue
+		}
+		machine := New(comp.Bytecode())
+		if err := machine.Run(); err != nil {
+			t.Errorf("vm error for %q: %s", input, err)
+			continue
+		}
+
+		if got := describe(machine.Result()); got != expected {
+			t.Errorf("engines disagree on %q.\nevaluator: %s\nvm:        %s", input, expected, got)
+		}
+	}
+}
+
+// evaluate runs input the way the evaluator's testEval does.
+func evaluate(input string) object.Object {
+	program := squ1dparser.New(lexer.New(input)).ParseProgram()
+	resolver.New(evaluator.BuiltinNames()...).Resolve(program)
+	return evaluator.Eval(program, object.NewEnvironment())
+}
+
+// evaluatorPrograms collects the arguments of every testEval call in the
+// test file at path, expanding tt.input to each input of the test's table.
+// Tests that define their own testEval are left out.
+func evaluatorPrograms(t *testing.T, path string) []string {
+	t.Helper()
+
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		t.Fatalf("cannot parse %s: %s", path, err)
+	}
+
+	seen := map[string]bool{}
+	programs := []string{}
+	add := func(program string) {
+		if !seen[program] {
+			seen[program] = true
+			programs = append(programs, program)
+		}
+	}
+
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || !strings.HasPrefix(fn.Name.Name, "Test") || definesTestEval(fn) {
+			continue
+		}
+
+		vars := map[string]string{}
+		inputs := []string{}
+		calls := []ast.Expr{}
+
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				if len(n.Lhs) == 1 && len(n.Rhs) == 1 {
+					if name, ok := n.Lhs[0].(*ast.Ident); ok {
+						if value, ok := stringValue(n.Rhs[0], vars, ""); ok {
+							vars[name.Name] = value
+						}
+					}
+				}
+			case *ast.CompositeLit:
+				if input, ok := tableInput(n, vars); ok {
+					inputs = append(inputs, input)
+				}
+			case *ast.CallExpr:
+				if ident, ok := n.Fun.(*ast.Ident); ok && ident.Name == "testEval" && len(n.Args) == 1 {
+					calls = append(calls, n.Args[0])
+				}
+			}
+			return true
+		})
+
+		for _, call := range calls {
+			if program, ok := stringValue(call, vars, ""); ok {
+				add(program)
+				continue
+			}
+			for _, input := range inputs {
+				if program, ok := stringValue(call, vars, input); ok {
+					add(program)
+				}
+			}
+		}
+	}
+
+	return programs
+}
+
+func definesTestEval(fn *ast.FuncDecl) bool {
+	defines := false
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		if assign, ok := n.(*ast.AssignStmt); ok {
+			for _, lhs := range assign.Lhs {
+				if ident, ok := lhs.(*ast.Ident); ok && ident.Name == "testEval" {
+					defines = true
+				}
+			}
+		}
+		return !defines
+	})
+	return defines
+}
+
+// tableInput returns the input of a test table entry: its field keyed
+// input, or else its first element.
+func tableInput(entry *ast.CompositeLit, vars map[string]string) (string, bool) {
+	if len(entry.Elts) == 0 {
+		return "", false
+	}
+	for _, elt := range entry.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if key, ok := kv.Key.(*ast.Ident); ok && key.Name == "input" {
+				return stringValue(kv.Value, vars, "")
+			}
+		}
+	}
+	return stringValue(entry.Elts[0], vars, "")
+}
+
+// stringValue evaluates a string expression made of literals, known
+// variables and concatenation. tt.input stands for input, and the
+// expression is not constant if input is empty.
+func stringValue(expr ast.Expr, vars map[string]string, input string) (string, bool) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		if expr.Kind != token.STRING {
+			return "", false
+		}
+		value, err := strconv.Unquote(expr.Value)
+		return value, err == nil
+	case *ast.Ident:
+		value, ok := vars[expr.Name]
+		return value, ok
+	case *ast.SelectorExpr:
+		if expr.Sel.Name == "input" && input != "" {
+			return input, true
+		}
+	case *ast.ParenExpr:
+		return stringValue(expr.X, vars, input)
+	case *ast.BinaryExpr:
+		if expr.Op != token.ADD {
+			return "", false
+		}
+		left, ok := stringValue(expr.X, vars, input)
+		if !ok {
+			return "", false
+		}
+		right, ok := stringValue(expr.Y, vars, input)
+		return left + right, ok
+	}
+	return "", false
+}
+
+// describe renders obj for comparison across engines, which represent
+// functions differently and may build hashes in a different order. Where a
+// function or block ends in a declaration the evaluator has no value and
+// the vm has null, so the two are rendered alike.
+func describe(obj object.Object) string {
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return "null"
+	case *object.Function, *object.Closure:
+		return "function"
+	case *object.Error:
+		return "error " + obj.Inspect()
+	case *object.Array:
+		elements := make([]string, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = describe(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *object.Hash:
+		pairs := []string{}
+		for _, pair := range obj.Pairs {
+			pairs = append(pairs, describe(pair.Key)+": "+describe(pair.Value))
+		}
+		sort.Strings(pairs)
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case *object.Instance:
+		fields := []string{}
+		for name, value := range obj.Fields {
+			fields = append(fields, name+": "+describe(value))
+		}
+		sort.Strings(fields)
+		return obj.Class.Name + "(" + strings.Join(fields, ", ") + ")"
+	case *object.Class:
+		return "class " + obj.Name
+	default:
+		return fmt.Sprintf("%s %s", obj.Type(), obj.Inspect())
+	}
+}
@@ -0,0 +1,24 @@
+package vm
+
+import (
+	"squ1d/code"
+	"squ1d/object"
+)
+
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+
+	// returns replaces the value the function returns, such as the new
+	// instance for an initializer.
+	returns object.Object
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
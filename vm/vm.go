@@ -0,0 +1,910 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"squ1d/code"
+	"squ1d/compiler"
+	"squ1d/evaluator"
+	"squ1d/object"
+)
+
+// MaxFrames leaves room for calls nested as deeply as the runtime allows
+// by default, so runaway recursion ends in the same error as under the
+// evaluator.
+const (
+	StackSize   = 1 << 16
+	GlobalsSize = 65536
+	MaxFrames   = object.DefaultMaxDepth + 1
+)
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:            "+",
+	code.OpSub:            "-",
+	code.OpMul:            "*",
+	code.OpDiv:            "/",
+	code.OpEqual:          "==",
+	code.OpNotEqual:       "!=",
+	code.OpGreaterThan:    ">",
+	code.OpLessThan:       "<",
+	code.OpIn:             "in",
+	code.OpNotIn:          "not in",
+	code.OpRange:          "..",
+	code.OpRangeInclusive: "..=",
+}
+
+// VM executes compiled bytecode. Operators, indexing, builtins, fields,
+// spreads and patterns share the evaluator's implementation, so both engines
+// give the same results and error messages; the VM only adds a fast path for
+// integer arithmetic.
+type VM struct {
+	constants []object.Object
+	builtins  []*object.Builtin
+
+	// env is handed to builtins, which take the caller's environment.
+	env *object.Environment
+
+	stack []object.Object
+	sp    int // Always points to the next free slot. Top of stack is stack[sp-1]
+
+	globals []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	lastPopped object.Object
+
+	// result is set when the program stops early, either through a
+	// top-level return or an error.
+	result object.Object
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	builtins := []*object.Builtin{}
+	for _, name := range evaluator.BuiltinNames() {
+		builtin, _ := evaluator.LookupBuiltin(name)
+		builtins = append(builtins, builtin)
+	}
+
+	return &VM{
+		constants: bytecode.Constants,
+		builtins:  builtins,
+		env:       object.NewEnvironment(),
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		globals: make([]object.Object, GlobalsSize),
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobalsStore runs bytecode against the globals of an earlier run,
+// which lets the REPL keep its variables between lines.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// SetRuntime attaches runtime to the environment builtins are called with,
+// which gives them its streams to work with. The program only sees the
+// runtime's builtins, and its calls and allocations are charged to it as
+// the evaluator charges them.
+func (vm *VM) SetRuntime(runtime *object.Runtime) {
+	vm.env.SetRuntime(runtime)
+
+	if runtime.Builtins() != nil {
+		for i, name := range evaluator.BuiltinNames() {
+			vm.builtins[i] = runtime.Builtins()[name]
+		}
+	}
+}
+
+// RunContext runs the program like Run, but stops it with an error whose
+// Cause is ctx.Err() once ctx is done, or object.ErrStepLimit once it has
+// made more than maxSteps calls, as evaluator.EvalContext does. The VM is
+// given a runtime if it has none.
+func (vm *VM) RunContext(ctx context.Context, maxSteps int64) error {
+	runtime := vm.env.Runtime()
+	if runtime == nil {
+		runtime = &object.Runtime{}
+		vm.SetRuntime(runtime)
+	}
+	runtime.Limit(ctx, maxSteps)
+	defer runtime.Release()
+
+	return vm.Run()
+}
+
+// Result is the value the program produced: the last expression statement,
+// a top-level return value, or the error that stopped it.
+func (vm *VM) Result() object.Object {
+	if vm.result != nil {
+		return vm.result
+	}
+	return vm.lastPopped
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// Run executes the program. Errors raised by the script stop it and become
+// its Result; the returned error is for faults in the VM itself, such as
+// running out of stack.
+func (vm *VM) Run() error {
+	defer vm.unwind()
+
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		var result object.Object
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			result = vm.constants[constIndex]
+			if _, ok := result.(*object.String); ok {
+				result = evaluator.Track(vm.env, result)
+			}
+
+		case code.OpPop:
+			vm.lastPopped = vm.pop()
+			continue
+
+		case code.OpClearResult:
+			vm.lastPopped = nil
+			continue
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+			code.OpIn, code.OpNotIn, code.OpRange, code.OpRangeInclusive:
+			right := vm.pop()
+			left := vm.pop()
+			result = vm.executeBinaryOperation(op, left, right)
+			if _, ok := result.(*object.Integer); !ok {
+				result = evaluator.Track(vm.env, result)
+			}
+
+		case code.OpMinus:
+			operand := vm.pop()
+			if integer, ok := operand.(*object.Integer); ok {
+				result = object.NewInteger(-integer.Value)
+			} else {
+				result = evaluator.ApplyPrefix("-", operand)
+			}
+
+		case code.OpBang:
+			result = evaluator.ApplyPrefix("!", vm.pop())
+
+		case code.OpTrue:
+			result = evaluator.TRUE
+
+		case code.OpFalse:
+			result = evaluator.FALSE
+
+		case code.OpNull:
+			result = evaluator.NULL
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+			continue
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if !evaluator.IsTruthy(vm.pop()) {
+				vm.currentFrame().ip = pos - 1
+			}
+			continue
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+			continue
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			result = vm.globals[globalIndex]
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			continue
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			result = vm.stack[frame.basePointer+int(localIndex)]
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			// A builtin the runtime leaves out is not there at all.
+			if builtin := vm.builtins[builtinIndex]; builtin != nil {
+				result = builtin
+			} else {
+				result = newError("Identifier not found: %s", evaluator.BuiltinNames()[builtinIndex])
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			result = vm.currentFrame().cl.Free[freeIndex]
+
+		case code.OpCurrentClosure:
+			result = vm.currentFrame().cl
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp -= numElements
+
+			result = evaluator.Track(vm.env, &object.Array{Elements: elements})
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			result = evaluator.Track(vm.env, vm.buildHash(vm.sp-numElements, vm.sp))
+			vm.sp -= numElements
+
+		case code.OpSet:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			result = evaluator.Track(vm.env, vm.buildSet(vm.sp-numElements, vm.sp))
+			vm.sp -= numElements
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			result = evaluator.ApplyIndex(left, index)
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			result = vm.newClosure(int(constIndex), int(numFree))
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			var err error
+			result, err = vm.executeCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+			if result == nil {
+				continue
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.returnFromFrame(returnValue) {
+				return nil
+			}
+			continue
+
+		case code.OpReturn:
+			if vm.returnFromFrame(evaluator.NULL) {
+				return nil
+			}
+			continue
+
+		case code.OpError:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			result = newError("%s", vm.constants[constIndex].(*object.String).Value)
+
+		case code.OpGetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			result = vm.cellAt(int(localIndex))
+
+		case code.OpSetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			vm.cellAt(int(localIndex)).value = vm.pop()
+			continue
+
+		case code.OpDeref:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			value := vm.pop()
+			if c, ok := value.(*cell); ok {
+				value = c.value
+			}
+			if value == nil {
+				continue
+			}
+			vm.currentFrame().ip = pos - 1
+			result = value
+
+		case code.OpSpread:
+			elements, err := evaluator.Spread(vm.env, vm.pop())
+			if err != nil {
+				result = err
+			} else {
+				result = &object.Array{Elements: append([]object.Object{}, elements...)}
+			}
+
+		case code.OpConcat:
+			numArrays := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := []object.Object{}
+			for _, arr := range vm.stack[vm.sp-numArrays : vm.sp] {
+				elements = append(elements, arr.(*object.Array).Elements...)
+			}
+			vm.sp -= numArrays
+
+			result = evaluator.Track(vm.env, &object.Array{Elements: elements})
+
+		case code.OpMerge:
+			numHashes := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			pairs := make(map[object.HashKey]object.HashPair)
+			for _, hash := range vm.stack[vm.sp-numHashes : vm.sp] {
+				if err := evaluator.MergeHash(pairs, hash); err != nil {
+					result = err
+					break
+				}
+			}
+			vm.sp -= numHashes
+
+			if result == nil {
+				result = evaluator.Track(vm.env, &object.Hash{Pairs: pairs})
+			}
+
+		case code.OpCallSpread:
+			numArgs, err := vm.pushArguments()
+			if err != nil {
+				return err
+			}
+
+			result, err = vm.executeCall(numArgs)
+			if err != nil {
+				return err
+			}
+			if result == nil {
+				continue
+			}
+
+		case code.OpGetField:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			result = evaluator.GetField(vm.pop(), vm.name(nameIndex))
+
+		case code.OpSetField:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.pop()
+			result = evaluator.SetField(vm.pop(), vm.name(nameIndex), value)
+
+		case code.OpMethodCall:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			numArgs := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			var err error
+			result, err = vm.executeMethodCall(vm.name(nameIndex), int(numArgs))
+			if err != nil {
+				return err
+			}
+			if result == nil {
+				continue
+			}
+
+		case code.OpMethodCallSpread:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			numArgs, err := vm.pushArguments()
+			if err != nil {
+				return err
+			}
+
+			result, err = vm.executeMethodCall(vm.name(nameIndex), numArgs)
+			if err != nil {
+				return err
+			}
+			if result == nil {
+				continue
+			}
+
+		case code.OpClass:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			numMethods := int(code.ReadUint8(ins[ip+3:]))
+			vm.currentFrame().ip += 3
+
+			methods := make(map[string]object.Object, numMethods)
+			for i := vm.sp - 2*numMethods; i < vm.sp; i += 2 {
+				methods[vm.stack[i].(*object.String).Value] = vm.stack[i+1]
+			}
+			vm.sp -= 2 * numMethods
+
+			result = &object.Class{Name: vm.name(nameIndex), Methods: methods}
+
+		case code.OpInherit:
+			superclass := vm.pop()
+			class := vm.pop().(*object.Class)
+
+			parent, ok := superclass.(*object.Class)
+			if !ok {
+				result = newError("Superclass must be a class, got %s", superclass.Type())
+			} else {
+				class.Superclass = parent
+				result = class
+			}
+
+		case code.OpDestructureArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			values, err := evaluator.DestructureArray(vm.pop(), numElements, rest)
+			if err != nil {
+				result = err
+				break
+			}
+			if err := vm.pushReversed(values); err != nil {
+				return err
+			}
+			continue
+
+		case code.OpDestructureHash:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			value := vm.pop()
+			names := vm.constants[constIndex].(*object.Array).Elements
+			values := make([]object.Object, len(names))
+			for i, name := range names {
+				field, err := evaluator.DestructureField(value, name.(*object.String).Value)
+				if err != nil {
+					result = err
+					break
+				}
+				values[i] = field
+			}
+			if result != nil {
+				break
+			}
+			if err := vm.pushReversed(values); err != nil {
+				return err
+			}
+			continue
+
+		case code.OpMatchArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			arr, ok := vm.pop().(*object.Array)
+			matched := ok && (len(arr.Elements) == numElements || rest && len(arr.Elements) > numElements)
+			result = nativeBoolToBooleanObject(matched)
+
+		case code.OpMatchField:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			key := vm.pop()
+			field, ok := evaluator.PatternField(vm.pop(), key)
+			if !ok {
+				vm.currentFrame().ip = pos - 1
+				continue
+			}
+			result = field
+
+		case code.OpMatchType:
+			nameIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			result = nativeBoolToBooleanObject(evaluator.HasTypeName(vm.pop(), vm.name(nameIndex)))
+
+		default:
+			def, err := code.Lookup(byte(op))
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("opcode %s not implemented", def.Name)
+		}
+
+		if err, ok := result.(*object.Error); ok {
+			vm.fail(err)
+			return nil
+		}
+
+		if err := vm.push(result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode, left, right object.Object) object.Object {
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+
+	if leftOk && rightOk {
+		l, r := leftInt.Value, rightInt.Value
+
+		switch op {
+		case code.OpAdd:
+			return object.NewInteger(l + r)
+		case code.OpSub:
+			return object.NewInteger(l - r)
+		case code.OpMul:
+			return object.NewInteger(l * r)
+		case code.OpDiv:
+			if r == 0 {
+				break
+			}
+			return object.NewInteger(l / r)
+		case code.OpEqual:
+			return nativeBoolToBooleanObject(l == r)
+		case code.OpNotEqual:
+			return nativeBoolToBooleanObject(l != r)
+		case code.OpGreaterThan:
+			return nativeBoolToBooleanObject(l > r)
+		case code.OpLessThan:
+			return nativeBoolToBooleanObject(l < r)
+		}
+	}
+
+	return evaluator.ApplyInfix(infixOperators[op], left, right)
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) object.Object {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError("Unusable as hash key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: hashedPairs}
+}
+
+func (vm *VM) buildSet(startIndex, endIndex int) object.Object {
+	set := object.NewSet()
+
+	for _, element := range vm.stack[startIndex:endIndex] {
+		hashKey, ok := element.(object.Hashable)
+		if !ok {
+			return newError("Unusable as set element: %s", element.Type())
+		}
+
+		set.Add(hashKey.HashKey(), element)
+	}
+
+	return set
+}
+
+func (vm *VM) newClosure(constIndex, numFree int) object.Object {
+	function := vm.constants[constIndex].(*object.CompiledFunction)
+
+	free := make([]object.Object, numFree)
+	copy(free, vm.stack[vm.sp-numFree:vm.sp])
+	vm.sp -= numFree
+
+	return &object.Closure{Fn: function, Free: free}
+}
+
+// executeCall calls the callee below the top numArgs stack slots. Builtins
+// and struct types run straight away and their result is returned; for
+// closures and classes a new frame is entered and the result is nil.
+func (vm *VM) executeCall(numArgs int) (object.Object, error) {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+
+	case *object.Class:
+		instance := &object.Instance{Class: callee, Fields: make(map[string]object.Object)}
+
+		initializer, definedIn := callee.FindMethod("init")
+		if initializer == nil {
+			if numArgs != 0 {
+				return newError("Wrong number of arguments to %s. Got %d, expected 0", callee.Name, numArgs), nil
+			}
+			vm.sp -= 1
+			return instance, nil
+		}
+
+		result, err := vm.callMethod(instance, definedIn, initializer, numArgs)
+		if result == nil && err == nil {
+			vm.currentFrame().returns = instance
+		}
+		return result, err
+
+	default:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp = vm.sp - numArgs - 1
+
+		result := evaluator.ApplyFunction(vm.env, callee, args)
+		if result == nil {
+			return evaluator.NULL, nil
+		}
+		return result, nil
+	}
+}
+
+// executeMethodCall calls the method name of the receiver below the top
+// numArgs stack slots, as executeCall calls a function.
+func (vm *VM) executeMethodCall(name string, numArgs int) (object.Object, error) {
+	receiver := vm.stack[vm.sp-1-numArgs]
+
+	switch receiver := receiver.(type) {
+	case *object.Instance:
+		if field, ok := receiver.Fields[name]; ok {
+			vm.stack[vm.sp-1-numArgs] = field
+			return vm.executeCall(numArgs)
+		}
+		method, definedIn := receiver.Class.FindMethod(name)
+		if method == nil {
+			return newError("Undefined method %s on %s", name, receiver.Class.Name), nil
+		}
+		return vm.callMethod(receiver, definedIn, method, numArgs)
+
+	case *object.Super:
+		method, definedIn := receiver.Class.FindMethod(name)
+		if method == nil {
+			return newError("Undefined method %s on %s", name, receiver.Class.Name), nil
+		}
+		return vm.callMethod(receiver.Self, definedIn, method, numArgs)
+
+	case *object.Struct:
+		field, ok := receiver.Fields[name]
+		if !ok {
+			return newError("Unknown field %s on %s", name, receiver.Definition.Name), nil
+		}
+		vm.stack[vm.sp-1-numArgs] = field
+		return vm.executeCall(numArgs)
+
+	default:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp = vm.sp - numArgs - 1
+
+		return evaluator.ApplyBuiltinMethod(vm.env, receiver, name, args), nil
+	}
+}
+
+// callMethod calls method with self, and super when the class defining it
+// has a parent, passed after the arguments.
+func (vm *VM) callMethod(self *object.Instance, definedIn *object.Class, method object.Object, numArgs int) (object.Object, error) {
+	receivers := []object.Object{self}
+	if definedIn.Superclass != nil {
+		receivers = append(receivers, &object.Super{Self: self, Class: definedIn.Superclass})
+	}
+	return vm.callClosure(method.(*object.Closure), numArgs, receivers...)
+}
+
+// callClosure enters a frame for cl, whose arguments are the top numArgs
+// stack slots. The receivers fill the slots following them.
+func (vm *VM) callClosure(cl *object.Closure, numArgs int, receivers ...object.Object) (object.Object, error) {
+	if numArgs != cl.Fn.NumParameters {
+		return wrongArguments(cl.Fn.Name, numArgs, cl.Fn.NumParameters), nil
+	}
+	if err := vm.enter(); err != nil {
+		return err, nil
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if vm.framesIndex >= MaxFrames || frame.basePointer+cl.Fn.NumLocals >= StackSize {
+		vm.leave()
+		return nil, fmt.Errorf("stack overflow")
+	}
+
+	locals := vm.stack[frame.basePointer+numArgs : frame.basePointer+cl.Fn.NumLocals]
+	for i := range locals {
+		locals[i] = nil
+	}
+	copy(locals, receivers)
+
+	vm.pushFrame(frame)
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+
+	return nil, nil
+}
+
+// enter charges a call to the runtime, if there is one, and records it
+// until the matching leave.
+func (vm *VM) enter() *object.Error {
+	runtime := vm.env.Runtime()
+	if runtime == nil {
+		return nil
+	}
+	if err := runtime.Step(); err != nil {
+		return err
+	}
+	return runtime.Enter()
+}
+
+func (vm *VM) leave() {
+	if runtime := vm.env.Runtime(); runtime != nil {
+		runtime.Leave()
+	}
+}
+
+// unwind leaves the frames still entered once the program has stopped.
+func (vm *VM) unwind() {
+	for vm.framesIndex > 1 {
+		vm.popFrame()
+		vm.leave()
+	}
+}
+
+// pushArguments replaces the array on top of the stack with its elements
+// and returns how many there are.
+func (vm *VM) pushArguments() (int, error) {
+	args := vm.pop().(*object.Array).Elements
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return 0, err
+		}
+	}
+	return len(args), nil
+}
+
+// pushReversed pushes values so that the first ends up on top.
+func (vm *VM) pushReversed(values []object.Object) error {
+	for i := len(values) - 1; i >= 0; i-- {
+		if err := vm.push(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cellAt returns the cell kept in the local slot index of the current
+// frame, putting a new one there if there is none yet.
+func (vm *VM) cellAt(index int) *cell {
+	slot := &vm.stack[vm.currentFrame().basePointer+index]
+	c, ok := (*slot).(*cell)
+	if !ok {
+		c = &cell{value: *slot}
+		*slot = c
+	}
+	return c
+}
+
+func (vm *VM) name(constIndex uint16) string {
+	return vm.constants[constIndex].(*object.String).Value
+}
+
+// returnFromFrame leaves the current function with value. Returning from
+// the main program stops it and reports true.
+func (vm *VM) returnFromFrame(value object.Object) bool {
+	if vm.framesIndex == 1 {
+		vm.result = value
+		return true
+	}
+
+	frame := vm.popFrame()
+	vm.leave()
+	vm.sp = frame.basePointer - 1
+
+	if frame.returns != nil {
+		value = frame.returns
+	}
+	vm.push(value)
+	return false
+}
+
+// fail stops the program with err, recording the named functions it
+// propagated out of, innermost first, as the evaluator does.
+func (vm *VM) fail(err *object.Error) {
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		frame := vm.frames[i]
+		if i == vm.framesIndex-1 && frame.ip < frame.cl.Fn.Prologue {
+			continue
+		}
+		if name := frame.cl.Fn.Name; name != "" {
+			err.Trace = append(err.Trace, name)
+		}
+	}
+	vm.result = err
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func wrongArguments(name string, got, expected int) *object.Error {
+	if name == "" {
+		return newError("Wrong number of arguments. Got %d, expected %d", got, expected)
+	}
+	return newError("Wrong number of arguments to %s. Got %d, expected %d", name, got, expected)
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return evaluator.TRUE
+	}
+	return evaluator.FALSE
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,765 @@
+package vm
+
+import (
+	"context"
+	"squ1d/compiler"
+	"squ1d/evaluator"
+	"squ1d/lexer"
+	"squ1d/object"
+	"squ1d/parser"
+	"testing"
+)
+
+// The tests below mirror evaluator_test.go so both engines are held to the
+// same behaviour.
+
+func TestEvalIntegerExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"5", 5},
+		{"10", 10},
+		{"-5", -5},
+		{"-10", -10},
+		{"5 + 5 + 5 + 5 - 10", 10},
+		{"2 * 2 * 2 * 2 * 2", 32},
+		{"-50 + 100 + -50", 0},
+		{"5 * 2 + 10", 20},
+		{"5 + 2 * 10", 25},
+		{"20 + 2 * -10", 0},
+		{"50 / 2 * 2 + 10", 60},
+		{"2 * (5 + 10)", 30},
+		{"3 * 3 * 3 + 10", 37},
+		{"3 * (3 * 3) + 10", 37},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	}
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("Object is not Integer. Got %T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("Object has wrong value. Got %d, expected %d", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func TestStringLiteral(t *testing.T) {
+	evaluated := testRun(t, `"Hello World!"`)
+	str, ok := evaluated.(*object.String)
+	if !ok {
+		t.Fatalf("Object is not String. Got %T (%+v)", evaluated, evaluated)
+	}
+
+	if str.Value != "Hello World!" {
+		t.Errorf("String has wrong value. Got %q", str.Value)
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	evaluated := testRun(t, "[1, 2 * 2, 3 + 3]")
+	result, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("Object is not Array. Got %T (%+v)", evaluated, evaluated)
+	}
+	if len(result.Elements) != 3 {
+		t.Fatalf("Array has wrong number of elements. Got %d",
+			len(result.Elements))
+	}
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"var i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"var myArray = [1, 2, 3]; myArray[2];", 3},
+		{"var myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"var myArray = [1, 2, 3]; var i = myArray[0]; myArray[i]", 2},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", nil},
+	}
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `var two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`
+
+	evaluated := testRun(t, input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval did not return Hash. Got %T (%+v)", evaluated, evaluated)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.String{Value: "one"}).HashKey():   1,
+		(&object.String{Value: "two"}).HashKey():   2,
+		(&object.String{Value: "three"}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():      4,
+		evaluator.TRUE.HashKey():                   5,
+		evaluator.FALSE.HashKey():                  6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong sum of pairs. Got %d", len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("No pair for given key in Pairs")
+		}
+
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`var key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
+	result, ok := obj.(*object.Boolean)
+	if !ok {
+		t.Errorf("Object is not Boolean. Got %T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("Object has wrong value. Got %t, expected %t", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func TestEvalBooleanExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 < 1", false},
+		{"1 > 1", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"1 == 2", false},
+		{"1 != 2", true},
+		{"true == true", true},
+		{"false == false", true},
+		{"true == false", false},
+		{"true != false", true},
+		{"false != true", true},
+		{"(1 < 2) == true", true},
+		{"(1 < 2) == false", false},
+		{"(1 > 2) == true", false},
+		{"(1 > 2) == false", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestIfElseExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"if (true) { 10 }", 10},
+		{"if (false) { 10 }", nil},
+		{"if (1) { 10 }", 10},
+		{"if (1 < 2) { 10 }", 10},
+		{"if (1 > 2) { 10 }", nil},
+		{"if (1 > 2) { 10 } el { 20 }", 20},
+		{"if (1 < 2) { 10 } el { 20 }", 10},
+		{"if (true) { var a = 1; }", nil},
+	}
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestFunctionObject(t *testing.T) {
+	evaluated := testRun(t, "def(x) { x + 2 };")
+	if _, ok := evaluated.(*object.Closure); !ok {
+		t.Fatalf("Object is not Closure. Got %T (%+v)", evaluated, evaluated)
+	}
+	if evaluated.Type() != object.FUNCTION_OBJ {
+		t.Fatalf("Closure has wrong type. Got %s", evaluated.Type())
+	}
+}
+
+func TestFunctionApplication(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"var identity = def(x) { x; }; identity(5);", 5},
+		{"var identity = def(x) { return x; }; identity(5);", 5},
+		{"var double = def(x) { x * 2; }; double(5);", 10},
+		{"var add = def(x, y) { x + y; }; add(5, 5);", 10},
+		{"var add = def(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+		{"def(x) { x; }(5)", 5},
+		{"var double = (x) => x * 2; double(4)", 8},
+		{"var f = def() { var a = 1; var b = 2; a + b }; f() + f()", 6},
+		{"var f = def(a) { var b = a * 2; if (b > 2) { var c = b; c } el { 0 } }; f(2)", 4},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testRun(t, tt.input), tt.expected)
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`cat("")`, 0},
+		{`cat("four")`, 4},
+		{`cat("hello world")`, 11},
+		{`cat([1, 2, 3])`, 3},
+		{`first([7, 8])`, 7},
+		{`last(add([1], 9))`, 9},
+		{`cat(1)`, "Argument to `cat` not supported, got INTEGER"},
+		{`cat("one", "two")`, "Wrong number of arguments. Got 2, expected 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func testNullObject(t *testing.T, obj object.Object) bool {
+	if obj != evaluator.NULL {
+		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
+		return false
+	}
+	return true
+}
+
+func testErrorObject(t *testing.T, obj object.Object, expected string) bool {
+	errObj, ok := obj.(*object.Error)
+	if !ok {
+		t.Errorf("Object is not Error. Got %T (%+v)", obj, obj)
+		return false
+	}
+	if errObj.Message != expected {
+		t.Errorf("Wrong error message. Expected %q, got %q", expected, errObj.Message)
+		return false
+	}
+	return true
+}
+
+func TestReturnStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"return 10;", 10},
+		{"return 10; 9;", 10},
+		{"return 2 * 5; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{
+			`
+if (10 > 1) {
+	if (10 > 1) {
+	return 10;
+}
+	return 1;
+}
+`,
+			10,
+		},
+		{"var f = def() { if (true) { return 1; } 2 }; f() + 10", 11},
+	}
+
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestErrorHandling(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{
+			"5 + true;",
+			"Type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"5 + true; 5;",
+			"Type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"-true",
+			"Unknown operator: -BOOLEAN",
+		},
+		{
+			"true + false;",
+			"Unknown operator: BOOLEAN + BOOLEAN",
+		},
+		{
+			"5; true + false; 5",
+			"Unknown operator: BOOLEAN + BOOLEAN",
+		},
+		{
+			"if (10 > 1) { true + false; }",
+			"Unknown operator: BOOLEAN + BOOLEAN",
+		},
+		{
+			`if (10 > 1) {
+			if (10 > 1) {
+			return true + false;
+		}
+			return 1;
+		}
+		`,
+			"Unknown operator: BOOLEAN + BOOLEAN",
+		},
+		{
+			"foobar",
+			"Identifier not found: foobar",
+		},
+		{
+			`"Hello" - "World"`,
+			"Unknown operator: STRING - STRING",
+		},
+		{
+			`{"name": "Monkey"}[def(x) { x }];`,
+			"Unusable as hash key: FUNCTION",
+		},
+		{
+			`{def(x) { x }: 1}`,
+			"Unusable as hash key: FUNCTION",
+		},
+		{
+			"var f = def() { 1 + true }; f(); 5",
+			"Type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			"5()",
+			"Not a function: INTEGER",
+		},
+		{
+			"def(x) { x }()",
+			"Wrong number of arguments. Got 0, expected 1",
+		},
+		{
+			"def f(a, b) { a }; f(1)",
+			"Wrong number of arguments to f. Got 1, expected 2",
+		},
+		{
+			"if (true) { var hidden = 1 }; hidden",
+			"Identifier not found: hidden",
+		},
+		{
+			"1 / 0",
+			"Division by zero",
+		},
+		{
+			"struct P { x }; P(1).y",
+			"Unknown field y on P",
+		},
+		{
+			"def f([a, b]) { a }; f([1])",
+			"Cannot destructure ARRAY of length 1 into 2 elements",
+		},
+	}
+	for _, tt := range tests {
+		testErrorObject(t, testRun(t, tt.input), tt.expectedMessage)
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	input := `
+def inner() { 1 + true }
+def outer() { inner() }
+var anonymous = def() { outer() };
+anonymous()`
+
+	evaluated := testRun(t, input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("Object is not Error. Got %T (%+v)", evaluated, evaluated)
+	}
+
+	expected := "ERROR: Type mismatch: INTEGER + BOOLEAN\n\tin inner\n\tin outer"
+	if errObj.Inspect() != expected {
+		t.Errorf("expected %q, got %q", expected, errObj.Inspect())
+	}
+}
+
+func TestBangOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"!true", false},
+		{"!false", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!!false", false},
+		{"!!5", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+// testRun compiles and runs input, turning compile errors into error
+// objects so they can be checked like runtime errors.
+func testRun(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error for %q: %s", input, err)
+	}
+
+	return vm.Result()
+}
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`
+var newAdder = def(x) {
+	def(y) { x + y };
+};
+
+var addTwo = newAdder(2);
+addTwo(2);`, 4},
+		{`
+var newAdder = def(a, b) {
+	var c = a + b;
+	def(d) { def(e) { c + d + e } };
+};
+newAdder(1, 2)(3)(4);`, 10},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testRun(t, tt.input), tt.expected)
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"var fib = def(n) { if (n < 2) { n } el { fib(n - 1) + fib(n - 2) } }; fib(15)", 610},
+		{"var wrapper = def() { var countDown = def(x) { x == 0 ? 0 : countDown(x - 1) }; countDown(3) }; wrapper() + 1", 1},
+		{"def even(n) { n == 0 ? true : odd(n - 1) }; def odd(n) { n == 0 ? false : even(n - 1) }; even(10) ? 1 : 0", 1},
+		{"var x = fact(5); def fact(n) { n < 2 ? 1 : n * fact(n - 1) }; x", 120},
+		{"var f = def() { def g(n) { n < 1 ? 0 : 1 + g(n - 1) }; g(4) }; f()", 4},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testRun(t, tt.input), tt.expected)
+	}
+}
+
+func TestStringConcatenation(t *testing.T) {
+	evaluated := testRun(t, `"Hello" + " " + "World!"`)
+	str, ok := evaluated.(*object.String)
+	if !ok {
+		t.Fatalf("Object is not String. Got %T (%+v)", evaluated, evaluated)
+	}
+
+	if str.Value != "Hello World!" {
+		t.Errorf("String has wrong value. Got %q", str.Value)
+	}
+}
+
+func TestLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"var a = 5; a;", 5},
+		{"var a = 5 * 5; a;", 25},
+		{"var a = 5; var b = a; b;", 5},
+		{"var a = 5; var b = a; var c = a + b + 5; c;", 15},
+		{"var a = 1; if (true) { var a = 2; }; a", 1},
+		{"var a = 1; if (true) { var b = a + 1; b } el { 0 }", 2},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testRun(t, tt.input), tt.expected)
+	}
+}
+
+func TestLanguageExtensions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"2 in {1, 2}", true},
+		{"cat({1, 2, 2})", 2},
+		{"3 not in [1, 2]", true},
+		{`"ell" in "hello"`, true},
+		{"cat(1..=10)", 10},
+		{"(0..10)[4]", 4},
+		{"1 > 2 ? 1 : 2", 2},
+		{"[1, 2] |> cat", 2},
+		{"tp(def() {})", "function"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testRun(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok || str.Value != expected {
+				t.Errorf("expected %q, got %+v", expected, evaluated)
+			}
+		}
+	}
+}
+
+func TestBlockScopingDisabled(t *testing.T) {
+	run := func(input string) object.Object {
+		comp := compiler.New()
+		comp.BlockScoping = false
+		if err := comp.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+			t.Fatalf("compiler error for %q: %s", input, err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", input, err)
+		}
+		return vm.Result()
+	}
+
+	testIntegerObject(t, run("var x = 1; if (true) { var x = 2; }; x"), 2)
+	testIntegerObject(t, run("if (true) { var y = 3; }; y"), 3)
+	testErrorObject(t, run("const c = 1; if (true) { var c = 2; }"), "Cannot reassign constant: c")
+}
+
+// newVM compiles input for a vm running under runtime.
+func newVM(t *testing.T, input string, runtime *object.Runtime) *VM {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+	vm := New(comp.Bytecode())
+	if runtime != nil {
+		vm.SetRuntime(runtime)
+	}
+	return vm
+}
+
+func TestExecutionLimits(t *testing.T) {
+	forever := "def f(n) { f(n + 1) }; f(0)"
+
+	vm := newVM(t, forever, nil)
+	if err := vm.RunContext(context.Background(), 100); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	errObj, ok := vm.Result().(*object.Error)
+	if !ok || errObj.Cause != object.ErrStepLimit {
+		t.Fatalf("expected a step limit error. got=%T(%+v)", vm.Result(), vm.Result())
+	}
+	if errObj.Message != "Step limit of 100 exceeded" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	vm = newVM(t, forever, nil)
+	if err := vm.RunContext(ctx, 0); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if errObj, ok := vm.Result().(*object.Error); !ok || errObj.Cause != context.Canceled {
+		t.Fatalf("expected a cancellation error. got=%T(%+v)", vm.Result(), vm.Result())
+	}
+
+	runtime := &object.Runtime{}
+	vm = newVM(t, "def g(n) { n }; g(1); g(2)", runtime)
+	if err := vm.RunContext(context.Background(), 1); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if steps := runtime.Steps(); steps != 2 {
+		t.Errorf("wrong step count. got=%d", steps)
+	}
+
+	runtime = &object.Runtime{}
+	runtime.LimitDepth(50)
+	class := "class A { def f(n) { if (n == 0) { 0 } el { self.f(n - 1) } } }; var a = A(); "
+	vm = newVM(t, class+"a.f(49)", runtime)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testIntegerObject(t, vm.Result(), 0)
+
+	vm = newVM(t, class+"a.f(50)", runtime)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if errObj, ok := vm.Result().(*object.Error); !ok || errObj.Cause != object.ErrCallDepth {
+		t.Errorf("expected a call depth error from methods. got=%T(%+v)", vm.Result(), vm.Result())
+	}
+
+	// The frames the error left are released, so the runtime can be
+	// used again.
+	vm = newVM(t, class+"a.f(49)", runtime)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testIntegerObject(t, vm.Result(), 0)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxBytes int64
+		expected interface{}
+	}{
+		{"def grow(s, n) { if (n == 0) { s } el { grow(s + s, n - 1) } }; grow(\"ab\", 30)", 1 << 20, "Memory limit of 1048576 bytes exceeded"},
+		{"def grow(a, n) { if (n == 0) { a } el { grow(add(a, n), n - 1) } }; grow([], 10000)", 1 << 20, "Memory limit of 1048576 bytes exceeded"},
+		{"[...1..1000000000]", 1 << 20, "Memory limit of 1048576 bytes exceeded"},
+		{"[...1..=100].len()", 1 << 20, 100},
+		{"[...1..1000000]; 1", 0, 1},
+	}
+
+	for _, tt := range tests {
+		runtime := &object.Runtime{}
+		runtime.LimitMemory(tt.maxBytes)
+		vm := newVM(t, tt.input, runtime)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, vm.Result(), int64(expected))
+		case string:
+			errObj, ok := vm.Result().(*object.Error)
+			if !ok || errObj.Message != expected || errObj.Cause != object.ErrMemoryLimit {
+				t.Errorf("wrong result for %q. got=%T(%+v)", tt.input, vm.Result(), vm.Result())
+			}
+		}
+	}
+}
+
+func TestRuntimeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`cat("abc")`, 3},
+		{`"abc".len()`, 3},
+		{"rand(1, 2)", "Identifier not found: rand"},
+		{"var rand = def(a, b) { a }; rand(1, 2)", 1},
+	}
+
+	for _, tt := range tests {
+		runtime := &object.Runtime{}
+		runtime.SetBuiltins(evaluator.Builtins())
+		vm := newVM(t, tt.input, runtime)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, vm.Result(), int64(expected))
+		case string:
+			testErrorObject(t, vm.Result(), expected)
+		}
+	}
+}